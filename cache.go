@@ -0,0 +1,200 @@
+package template
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"sync"
+	"time"
+)
+
+// outputCache is a bounded LRU cache of rendered outputs, keyed by the
+// template key and a hash of the render data. Entries expire after ttl
+// when ttl is positive.
+type outputCache struct {
+	size    int
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]*list.Element
+	order   *list.List
+	mutex   sync.Mutex
+}
+
+// outputEntry holds a rendered output and its lazily compressed form.
+type outputEntry struct {
+	key     string
+	raw     []byte
+	expires time.Time
+	once    sync.Once
+	gz      []byte
+	err     error
+}
+
+// newOutputCache creates an output cache holding at most size entries
+// for at most ttl each. A zero ttl keeps entries until evicted.
+func newOutputCache(size int, ttl time.Duration, now func() time.Time) *outputCache {
+	return &outputCache{
+		size:    size,
+		ttl:     ttl,
+		now:     now,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the entry stored for key and marks it as recently used.
+func (c *outputCache) get(key string) (*outputEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	// Drop expired entry
+	if c.expired(el.Value.(*outputEntry)) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return el.Value.(*outputEntry), true
+}
+
+// put stores raw output for key, evicting the least recently used
+// entries when the cache is full.
+func (c *outputCache) put(key string, raw []byte) *outputEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		if !c.expired(el.Value.(*outputEntry)) {
+			c.order.MoveToFront(el)
+			return el.Value.(*outputEntry)
+		}
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+
+	entry := &outputEntry{key: key, raw: raw}
+	if c.ttl > 0 {
+		entry.expires = c.now().Add(c.ttl)
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*outputEntry).key)
+	}
+
+	return entry
+}
+
+// expired reports whether the entry outlived the cache ttl.
+func (c *outputCache) expired(entry *outputEntry) bool {
+	return c.ttl > 0 && !c.now().Before(entry.expires)
+}
+
+// clear removes all entries.
+func (c *outputCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// compressed returns the gzipped output, compressing it on first use.
+func (e *outputEntry) compressed() ([]byte, error) {
+	e.once.Do(func() {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, e.err = w.Write(e.raw); e.err != nil {
+			return
+		}
+		if e.err = w.Close(); e.err != nil {
+			return
+		}
+		e.gz = buf.Bytes()
+	})
+	return e.gz, e.err
+}
+
+// failureCacheSize bounds the failures remembered by the error cooldown.
+const failureCacheSize = 1024
+
+// failureCache remembers render failures per template key for a cooldown
+// period, as measured by the engine clock. It holds at most size entries.
+type failureCache struct {
+	size     int
+	cooldown time.Duration
+	now      func() time.Time
+	entries  map[string]failureEntry
+	mutex    sync.Mutex
+}
+
+// failureEntry holds a render error and the time it stops being served.
+type failureEntry struct {
+	err     error
+	expires time.Time
+}
+
+// newFailureCache creates a failure cache keeping at most size errors for
+// cooldown each.
+func newFailureCache(size int, cooldown time.Duration, now func() time.Time) *failureCache {
+	return &failureCache{
+		size:     size,
+		cooldown: cooldown,
+		now:      now,
+		entries:  make(map[string]failureEntry),
+	}
+}
+
+// get returns the error stored for key until its cooldown elapses.
+func (c *failureCache) get(key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	// Drop elapsed entry
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	return entry.err
+}
+
+// put stores the error for key for the cooldown period. When the cache is
+// full, elapsed entries are dropped first and the error is not stored if
+// none elapsed.
+func (c *failureCache) put(key string, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.size {
+			return
+		}
+	}
+	c.entries[key] = failureEntry{err: err, expires: now.Add(c.cooldown)}
+}
+
+// clear removes all entries.
+func (c *failureCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = make(map[string]failureEntry)
+}
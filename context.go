@@ -36,6 +36,33 @@ func (ctx *Context) Add(key string, value any) *Context {
 	return ctx
 }
 
+// AddIf inserts a key-value pair into the Context when cond is true.
+func (ctx *Context) AddIf(cond bool, key string, value any) *Context {
+	if cond {
+		ctx.Add(key, value)
+	}
+	return ctx
+}
+
+// AddNonZero inserts a key-value pair into the Context unless the value is
+// nil, a nil pointer, an empty string, slice or map, or a zero number, using
+// the same checks as the "deepAlter" pipe.
+func (ctx *Context) AddNonZero(key string, value any) *Context {
+	if !isEmpty(value) {
+		ctx.Add(key, value)
+	}
+	return ctx
+}
+
+// AddAll inserts all key-value pairs of values into the Context, overriding
+// existing keys. Empty keys are ignored.
+func (ctx *Context) AddAll(values map[string]any) *Context {
+	for key, value := range values {
+		ctx.Add(key, value)
+	}
+	return ctx
+}
+
 // Data returns the underlying map of the Context.
 func (ctx *Context) Data() map[string]any {
 	return ctx.data
@@ -3,17 +3,17 @@ module github.com/go-universal/template
 go 1.24.2
 
 require (
+	github.com/aymerick/douceur v0.2.0
 	github.com/go-universal/fs v0.0.1
 	github.com/go-universal/utils v0.0.1
 	github.com/google/uuid v1.6.0
+	golang.org/x/net v0.33.0
 )
 
 require (
-	github.com/aymerick/douceur v0.2.0 // indirect
 	github.com/gabriel-vasile/mimetype v1.4.8 // indirect
 	github.com/gorilla/css v1.0.1 // indirect
 	github.com/microcosm-cc/bluemonday v1.0.27 // indirect
 	golang.org/x/image v0.25.0 // indirect
-	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/text v0.23.0 // indirect
 )
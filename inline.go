@@ -0,0 +1,289 @@
+package template
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aymerick/douceur/css"
+	"github.com/aymerick/douceur/parser"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// compoundRx matches a compound selector made of an optional type selector
+// followed by id and class selectors, e.g. "p#intro.lead".
+var compoundRx = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9-]*|\*)?((?:[#.][a-zA-Z_-][a-zA-Z0-9_-]*)*)$`)
+
+// simpleRx matches the id and class selectors of a compound selector.
+var simpleRx = regexp.MustCompile(`[#.][^#.]+`)
+
+// cssCompound is a compound selector and the combinator joining it to the
+// previous compound of its selector.
+type cssCompound struct {
+	tag     string
+	id      string
+	classes []string
+	child   bool
+}
+
+// cssSelector is an inlinable selector made of compounds joined by
+// descendant or child combinators.
+type cssSelector struct {
+	compounds   []cssCompound
+	specificity int
+}
+
+// inlineRule is a style rule applied to the elements matching its selector.
+type inlineRule struct {
+	selector     *cssSelector
+	declarations []*css.Declaration
+}
+
+// inlineCSS moves the rules of <style> blocks into the style attribute of
+// matching elements. Rules that cannot be inlined, such as at-rules (e.g.
+// @media) and selectors with pseudo-classes, attributes or sibling
+// combinators, are kept in a single <style> block in the document head.
+// Style blocks with a data-inline="false" attribute are left untouched.
+// Existing style attributes win over inlined rules, except !important ones.
+func inlineCSS(raw []byte) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect style blocks
+	var styles []*html.Node
+	walkElements(doc, func(n *html.Node) {
+		if n.DataAtom == atom.Style && attr(n, "data-inline") != "false" {
+			styles = append(styles, n)
+		}
+	})
+	if len(styles) == 0 {
+		return raw, nil
+	}
+
+	// Split inlinable and raw rules
+	var rules []inlineRule
+	var kept []string
+	for _, style := range styles {
+		var source strings.Builder
+		for c := style.FirstChild; c != nil; c = c.NextSibling {
+			source.WriteString(c.Data)
+		}
+
+		sheet, err := parser.Parse(source.String())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rule := range sheet.Rules {
+			if rule.Kind != css.QualifiedRule {
+				kept = append(kept, rule.String())
+				continue
+			}
+
+			var rawSelectors []string
+			for _, sel := range rule.Selectors {
+				if selector, ok := parseSelector(sel); ok {
+					rules = append(rules, inlineRule{selector, rule.Declarations})
+				} else {
+					rawSelectors = append(rawSelectors, sel)
+				}
+			}
+			if len(rawSelectors) > 0 {
+				rule.Selectors = rawSelectors
+				kept = append(kept, rule.String())
+			}
+		}
+
+		style.Parent.RemoveChild(style)
+	}
+
+	// Apply rules by specificity, then source order
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].selector.specificity < rules[j].selector.specificity
+	})
+	walkElements(doc, func(n *html.Node) {
+		var declarations []*css.Declaration
+		for _, rule := range rules {
+			if rule.selector.match(n) {
+				declarations = append(declarations, rule.declarations...)
+			}
+		}
+		if len(declarations) == 0 {
+			return
+		}
+
+		// Existing inline style comes last
+		if inline := strings.TrimSpace(attr(n, "style")); inline != "" {
+			// The parser drops the value of an unterminated last declaration
+			if !strings.HasSuffix(inline, ";") {
+				inline += ";"
+			}
+			if parsed, err := parser.ParseDeclarations(inline); err == nil {
+				declarations = append(declarations, parsed...)
+			}
+		}
+		setAttr(n, "style", mergeDeclarations(declarations))
+	})
+
+	// Keep raw rules in head
+	if len(kept) > 0 {
+		style := &html.Node{Type: html.ElementNode, Data: "style", DataAtom: atom.Style}
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: strings.Join(kept, "\n")})
+		walkElements(doc, func(n *html.Node) {
+			if n.DataAtom == atom.Head {
+				n.AppendChild(style)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parseSelector parses an inlinable selector. It reports false for
+// selectors that cannot be resolved statically against an element.
+func parseSelector(sel string) (*cssSelector, bool) {
+	res := &cssSelector{}
+	child := false
+	for _, token := range strings.Fields(strings.ReplaceAll(sel, ">", " > ")) {
+		if token == ">" {
+			if child || len(res.compounds) == 0 {
+				return nil, false
+			}
+			child = true
+			continue
+		}
+
+		m := compoundRx.FindStringSubmatch(token)
+		if m == nil {
+			return nil, false
+		}
+
+		compound := cssCompound{child: child}
+		if m[1] != "" && m[1] != "*" {
+			compound.tag = strings.ToLower(m[1])
+			res.specificity++
+		}
+		for _, part := range simpleRx.FindAllString(m[2], -1) {
+			if part[0] == '#' {
+				compound.id = part[1:]
+				res.specificity += 10000
+			} else {
+				compound.classes = append(compound.classes, part[1:])
+				res.specificity += 100
+			}
+		}
+
+		res.compounds = append(res.compounds, compound)
+		child = false
+	}
+
+	return res, len(res.compounds) > 0 && !child
+}
+
+// match reports whether the element matches the selector.
+func (s *cssSelector) match(n *html.Node) bool {
+	return s.matchAt(n, len(s.compounds)-1)
+}
+
+// matchAt matches the element against the compound at i and its ancestors
+// against the previous compounds.
+func (s *cssSelector) matchAt(n *html.Node, i int) bool {
+	if !s.compounds[i].match(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+
+	for p := n.Parent; p != nil && p.Type == html.ElementNode; p = p.Parent {
+		if s.matchAt(p, i-1) {
+			return true
+		}
+		if s.compounds[i].child {
+			return false
+		}
+	}
+	return false
+}
+
+// match reports whether the element matches the compound selector.
+func (c cssCompound) match(n *html.Node) bool {
+	if c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	if c.id != "" && attr(n, "id") != c.id {
+		return false
+	}
+
+	classes := strings.Fields(attr(n, "class"))
+	for _, class := range c.classes {
+		if !contains(classes, class) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeDeclarations serializes declarations into a style attribute value.
+// Later declarations override earlier ones, unless those are !important.
+func mergeDeclarations(declarations []*css.Declaration) string {
+	var order []string
+	merged := make(map[string]*css.Declaration)
+	for _, decl := range declarations {
+		prev, ok := merged[decl.Property]
+		if !ok {
+			order = append(order, decl.Property)
+		} else if prev.Important && !decl.Important {
+			continue
+		}
+		merged[decl.Property] = decl
+	}
+
+	res := make([]string, 0, len(order))
+	for _, property := range order {
+		res = append(res, merged[property].String())
+	}
+	return strings.Join(res, " ")
+}
+
+// walkElements calls fn for every element node of the tree in document
+// order. The next sibling is resolved before fn, so fn may detach n.
+func walkElements(n *html.Node, fn func(n *html.Node)) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode {
+			fn(c)
+		}
+		walkElements(c, fn)
+		c = next
+	}
+}
+
+// attr returns the value of the element attribute key.
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// setAttr sets the value of the element attribute key.
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
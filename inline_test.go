@@ -0,0 +1,94 @@
+package template
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInlineCSS(t *testing.T) {
+	tests := []struct {
+		name, html string
+		want, not  []string
+	}{
+		{
+			name: "type class and id",
+			html: `<style>p { color: red; } .lead { font-size: 14px; } #intro { margin: 0; }</style><p id="intro" class="lead">x</p>`,
+			want: []string{`style="color: red; font-size: 14px; margin: 0;"`},
+			not:  []string{"<style>"},
+		},
+		{
+			name: "specificity",
+			html: `<style>.a { color: red; } p { color: blue; }</style><p class="a">x</p>`,
+			want: []string{`style="color: red;"`},
+		},
+		{
+			name: "important",
+			html: `<style>p { color: red !important; } .a { color: blue; }</style><p class="a" style="color: green">x</p>`,
+			want: []string{`style="color: red !important;"`},
+		},
+		{
+			name: "existing style with semicolon",
+			html: `<style>p { color: red; margin: 0; }</style><p style="color: blue;">x</p>`,
+			want: []string{`style="color: blue; margin: 0;"`},
+		},
+		{
+			name: "existing style without semicolon",
+			html: `<style>p { color: red; font-size: 10px; }</style><p style="color: blue; font-size:12px">x</p>`,
+			want: []string{`style="color: blue; font-size: 12px;"`},
+		},
+		{
+			name: "combinators",
+			html: `<style>div > p { color: red; } ul a { color: blue; }</style><div><p>x</p><span><p>y</p></span></div><ul><li><a>z</a></li></ul>`,
+			want: []string{`<div><p style="color: red;">x</p><span><p>y</p></span></div>`, `<a style="color: blue;">z</a>`},
+		},
+		{
+			name: "kept rules",
+			html: `<html><head><style>@media (max-width: 600px) { p { color: red; } } a:hover { color: blue; } p { margin: 0; }</style></head><body><p>x</p></body></html>`,
+			want: []string{`<p style="margin: 0;">`, "@media", "a:hover"},
+		},
+		{
+			name: "skipped block",
+			html: `<style data-inline="false">p { color: red; }</style><p>x</p>`,
+			want: []string{`<style data-inline="false">p { color: red; }</style>`, `<p>x</p>`},
+		},
+	}
+	for _, tt := range tests {
+		out, err := inlineCSS([]byte(tt.html))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(string(out), want) {
+				t.Errorf("%s: %s does not contain %s", tt.name, out, want)
+			}
+		}
+		for _, not := range tt.not {
+			if strings.Contains(string(out), not) {
+				t.Errorf("%s: %s contains %s", tt.name, out, not)
+			}
+		}
+	}
+}
+
+func TestCSSInliningOption(t *testing.T) {
+	tpl := newTestEngine(t, map[string]string{
+		"views/emails/welcome.tpl": `<style>p { color: red; }</style><p>{{ .Name }}</p>`,
+		"views/pages/home.tpl":     `<style>p { color: red; }</style><p>{{ .Name }}</p>`,
+	}, WithCSSInlining("emails/*"))
+
+	email, err := tpl.Compile("emails/welcome", "", map[string]any{"Name": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(email), `<p style="color: red;">x</p>`) {
+		t.Fatalf("email not inlined: %s", email)
+	}
+
+	page, err := tpl.Compile("pages/home", "", map[string]any{"Name": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), "<style>") {
+		t.Fatalf("unmatched view inlined: %s", page)
+	}
+}
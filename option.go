@@ -4,27 +4,84 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"reflect"
+	"math"
 	"strings"
+	"time"
 
 	"github.com/go-universal/utils"
 	"github.com/google/uuid"
 )
 
 type option struct {
-	root       string
-	partials   string
-	extension  string
-	leftDelim  string
-	rightDelim string
-	Dev        bool
-	Cache      bool
-	Pipes      template.FuncMap
+	root           string
+	partials       string
+	partialPrefix  string
+	partialAliases bool
+	macros         string
+	globals        []string
+	autoIncludes   []string
+	extension      string
+	extensionless  bool
+	mimes          map[string]string
+	transformers   map[string]func([]byte) ([]byte, error)
+	leftDelim      string
+	rightDelim     string
+	Dev            bool
+	Cache          bool
+	Pipes          template.FuncMap
+	pipeNames      map[string]string
+	wrapErrors     bool
+	escapeJSON     bool
+	profile        bool
+	sourceCache    bool
+	cspReport      bool
+	routeKey       string
+	scopeKey       string
+
+	sandboxPipes     []string
+	trustedPipes     []string
+	auditPipes       bool
+	sandboxTemplates []string
+	uncacheable      []string
+	inlineCSS        []string
+
+	outputCacheSize int
+	maxPartials     int
+	outputCacheTTL  time.Duration
+	errorCooldown   time.Duration
+	readTimeout     time.Duration
+	now             func() time.Time
+	timeZone        *time.Location
+	logger          Logger
+
+	layoutResolver func(view string, data any) string
+	afterLoad      []func(Template) error
+	onReload       []func()
+	beforeRender   []BeforeRenderHook
+	afterRender    []AfterRenderHook
 }
 
 // Options represents a configuration option for the Template.
 type Options func(*option)
 
+// Logger is a minimal structured logger the engine reports to. Fields are
+// passed as alternating key-value pairs.
+type Logger interface {
+	Debug(msg string, fields ...any)
+	Warn(msg string, fields ...any)
+	Error(msg string, fields ...any)
+}
+
+// BeforeRenderHook is called before a view is executed. It receives the
+// normalized view name and data and returns the data to render with.
+// Returning an error aborts the render.
+type BeforeRenderHook func(name string, data any) (any, error)
+
+// AfterRenderHook is called with the normalized view name and the final
+// rendered output and returns the output to write. Returning an error
+// aborts the render.
+type AfterRenderHook func(name string, output []byte) ([]byte, error)
+
 // WithRoot sets the root directory for templates. Default is ".".
 func WithRoot(root string) Options {
 	root = normalizePath(root)
@@ -46,6 +103,130 @@ func WithPartials(path string) Options {
 	}
 }
 
+// WithPartialPrefix sets the namespace partials are registered under.
+// Default is "@partials/". A trailing slash is added to a non-empty prefix.
+//
+// An empty prefix registers partials by their bare relative name. Use it with
+// care: a partial named "home" shadows any template defined as "home".
+func WithPartialPrefix(prefix string) Options {
+	prefix = strings.TrimSpace(prefix)
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	return func(opt *option) {
+		opt.partialPrefix = prefix
+	}
+}
+
+// WithPartialAliases additionally registers nested partials under their last
+// path segment when unambiguous. Partials are always registered under their
+// path relative to the partials directory, e.g. "views/partials/ui/forms/input.tpl"
+// as "@partials/ui/forms/input"; with aliases it is also "@partials/input".
+// An alias shared by two partials is not registered; Load fails when an
+// alias equals the name of another partial.
+func WithPartialAliases() Options {
+	return func(opt *option) {
+		opt.partialAliases = true
+	}
+}
+
+// WithGlobalTemplates force-loads the named templates into the shared base,
+// registered under their normalized name (e.g. "shared/icons"), regardless
+// of the partials directory. They are available to include, require and
+// template everywhere but cannot be rendered directly. A missing file fails Load.
+func WithGlobalTemplates(names ...string) Options {
+	return func(opt *option) {
+		for _, name := range names {
+			if name = strings.TrimSpace(name); name != "" {
+				opt.globals = append(opt.globals, name)
+			}
+		}
+	}
+}
+
+// WithAutoInclude parses the named templates (e.g. "helpers/icons") into every
+// compiled view before the view, layout and partials, so their define blocks
+// are callable everywhere. Unlike WithGlobalTemplates, they are not part of
+// the shared base: the view, layout and partials may redefine their blocks.
+// They are constant, so the cache key of views is unchanged. A missing file
+// fails the render.
+func WithAutoInclude(names ...string) Options {
+	return func(opt *option) {
+		for _, name := range names {
+			if name = strings.TrimSpace(name); name != "" {
+				opt.autoIncludes = append(opt.autoIncludes, name)
+			}
+		}
+	}
+}
+
+// WithExtensionMIME maps a file extension to the MIME type reported by
+// ContentType. The compound extension (".json.tpl" in "user.json.tpl") is
+// matched first, then the format extension (".json") against this mapping
+// and the standard MIME table. Otherwise the template extension itself is
+// matched against this mapping, and "text/html; charset=utf-8" is the default.
+func WithExtensionMIME(ext, mime string) Options {
+	ext, mime = strings.TrimSpace(ext), strings.TrimSpace(mime)
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return func(opt *option) {
+		if ext != "" && mime != "" {
+			if opt.mimes == nil {
+				opt.mimes = make(map[string]string)
+			}
+			opt.mimes[ext] = mime
+		}
+	}
+}
+
+// WithSourceTransformer registers fn to transform the source of template files
+// ending with ext (e.g. ".slim.tpl") before they are parsed, so templates can
+// be authored in an alternative syntax. It applies to views, layouts,
+// partials, macros and global templates alike. When several extensions match
+// a file, the longest one wins. The transformed source is parsed with the
+// configured delimiters, so fn must emit them. Transformer errors fail the
+// load or render and are prefixed with the file path.
+func WithSourceTransformer(ext string, fn func([]byte) ([]byte, error)) Options {
+	ext = strings.TrimSpace(ext)
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return func(opt *option) {
+		if ext != "" && fn != nil {
+			if opt.transformers == nil {
+				opt.transformers = make(map[string]func([]byte) ([]byte, error))
+			}
+			opt.transformers[ext] = fn
+		}
+	}
+}
+
+// WithExtensionlessNames enforces extensionless template references. Names
+// passed to Render, Exists and friends always get the extension appended, and
+// names ending with it (e.g. "pages/home.tpl") are rejected instead of being
+// silently normalized. Names are relative to the root; a leading slash is
+// ignored and dots within names are kept, so "home.tpl.bak" resolves to
+// "home.tpl.bak.tpl".
+func WithExtensionlessNames() Options {
+	return func(opt *option) {
+		opt.extensionless = true
+	}
+}
+
+// WithMacros sets the macros path for templates. Macro files are parsed into
+// the shared base, so their {{ define }} blocks can be called by name from
+// every view with {{ template "name" . }} or include. Macro files cannot be
+// rendered directly and defining the same name in two macro files fails Load.
+func WithMacros(path string) Options {
+	path = normalizePath(path)
+	return func(opt *option) {
+		if path != "" && path != "." {
+			opt.macros = path + "/"
+		}
+	}
+}
+
 // WithExtension sets the file extension for templates. Default is ".tpl".
 func WithExtension(ext string) Options {
 	ext = strings.TrimSpace(ext)
@@ -59,6 +240,17 @@ func WithExtension(ext string) Options {
 	}
 }
 
+// WithLayoutResolver sets a function that picks the layout when Render or
+// Compile is called without one (or with an empty layout), e.g. based on
+// whether the user is authenticated. It receives the normalized view name
+// and the render data; returning "" renders without a layout. An explicit
+// layout argument always overrides the resolver.
+func WithLayoutResolver(fn func(view string, data any) string) Options {
+	return func(opt *option) {
+		opt.layoutResolver = fn
+	}
+}
+
 // WithDelimeters sets custom delimiters for templates. Default is "{{" and "}}".
 func WithDelimeters(left, right string) Options {
 	left, right = strings.TrimSpace(left), strings.TrimSpace(right)
@@ -70,12 +262,55 @@ func WithDelimeters(left, right string) Options {
 }
 
 // WithEnv sets the environment to development or production mode.
+// In development mode templates are reloaded on every call, independent
+// of the lazy one-time load used in production mode.
 func WithEnv(isDev bool) Options {
 	return func(opt *option) {
 		opt.Dev = isDev
 	}
 }
 
+// WithPartialSourceCache keeps the parse trees of partials between loads,
+// keyed by file path, modification time and size. Reloads (e.g. on every
+// request in development mode) skip reading and parsing unchanged partials
+// and only reparse changed ones, while always reflecting the files on disk.
+// Filesystems without modification times, such as embed.FS, never change.
+func WithPartialSourceCache() Options {
+	return func(opt *option) {
+		opt.sourceCache = true
+	}
+}
+
+// WithContentSecurityReport scans rendered output in development mode for
+// markup a strict Content Security Policy would block: inline <script> blocks
+// without a nonce, inline style attributes and javascript: URLs. Findings are
+// read with ContentSecurityReport. The scan runs after all after render hooks
+// and never runs in production mode.
+func WithContentSecurityReport() Options {
+	return func(opt *option) {
+		opt.cspReport = true
+	}
+}
+
+// WithProfile records how long the view, layout and every included partial
+// take to execute, exposed per render by LastRenderProfile. Durations of
+// nested includes are inclusive. Intended for development and profiling only.
+func WithProfile() Options {
+	return func(opt *option) {
+		opt.profile = true
+	}
+}
+
+// WithReadTimeout aborts template file reads in Load and Render that take longer
+// than d with a descriptive error. Useful with network backed filesystems.
+// Disabled by default. The timeout is best-effort: a read that cannot be
+// cancelled keeps running in the background and its result is discarded.
+func WithReadTimeout(d time.Duration) Options {
+	return func(opt *option) {
+		opt.readTimeout = d
+	}
+}
+
 // WithCache enables caching for templates. Disabled by default.
 func WithCache() Options {
 	return func(opt *option) {
@@ -83,6 +318,224 @@ func WithCache() Options {
 	}
 }
 
+// WithFuncErrorWrapping annotates errors returned by pipes (custom and built-in)
+// with the pipe name and a truncated rendering of its arguments.
+func WithFuncErrorWrapping() Options {
+	return func(opt *option) {
+		opt.wrapErrors = true
+	}
+}
+
+// WithAfterLoad registers a callback invoked at the end of every successful Load,
+// after partials are registered and the engine lock is released, e.g. to assert
+// required partials exist or log startup details. Returning an error fails Load
+// and the load is retried, hooks included, on the next call.
+//
+// The callback may call back into the engine through the Template it
+// receives, which is pinned to the templates being loaded. Calling the
+// engine through another reference from the callback deadlocks.
+func WithAfterLoad(fn func(t Template) error) Options {
+	return func(opt *option) {
+		if fn != nil {
+			opt.afterLoad = append(opt.afterLoad, fn)
+		}
+	}
+}
+
+// WithReloadCallback registers a callback invoked at the end of every
+// successful Load, including lazy and development mode reloads, e.g. to purge
+// downstream page caches in sync with template changes. It runs after the
+// WithAfterLoad callbacks, outside of the engine lock, so it may call back
+// into the engine under the same conditions.
+func WithReloadCallback(fn func()) Options {
+	return func(opt *option) {
+		if fn != nil {
+			opt.onReload = append(opt.onReload, fn)
+		}
+	}
+}
+
+// WithBeforeRender registers a hook that runs before each render, e.g. to
+// start a trace span or transform the data. Hooks run in registration order.
+func WithBeforeRender(fn BeforeRenderHook) Options {
+	return func(opt *option) {
+		if fn != nil {
+			opt.beforeRender = append(opt.beforeRender, fn)
+		}
+	}
+}
+
+// WithAfterRender registers a hook that post-processes the rendered output,
+// e.g. to inject a CSP nonce. Hooks run in registration order, after every
+// other output transformation, so they always see the final bytes.
+// Registering an after hook buffers the whole output before writing.
+func WithAfterRender(fn AfterRenderHook) Options {
+	return func(opt *option) {
+		if fn != nil {
+			opt.afterRender = append(opt.afterRender, fn)
+		}
+	}
+}
+
+// WithCompressionCache memoizes the output of Compile and CompileGzip and its
+// gzip per view and data, keeping at most size entries.
+func WithCompressionCache(size int) Options {
+	return WithOutputCache(size, 0)
+}
+
+// WithErrorCooldown returns the last syntax or escaping error of a failing
+// view for d instead of compiling it again on every render. Read and
+// execution errors are not remembered. Disabled in development mode.
+func WithErrorCooldown(d time.Duration) Options {
+	return func(opt *option) {
+		opt.errorCooldown = d
+	}
+}
+
+// WithOutputCache memoizes output like WithCompressionCache and expires
+// entries ttl after they were rendered.
+func WithOutputCache(size int, ttl time.Duration) Options {
+	return func(opt *option) {
+		opt.outputCacheSize = max(size, 0)
+		opt.outputCacheTTL = max(ttl, 0)
+	}
+}
+
+// WithUncacheableViews excludes views from the compiled template cache and
+// the compression cache, so they are always compiled and rendered fresh. The
+// patterns are globs matched against the normalized view name, relative to
+// the root and without extension (e.g. "pages/account/*"), using path.Match
+// semantics: "*" does not cross "/", and invalid patterns never match.
+func WithUncacheableViews(patterns ...string) Options {
+	return func(opt *option) {
+		for _, pattern := range patterns {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				opt.uncacheable = append(opt.uncacheable, toSlash(pattern))
+			}
+		}
+	}
+}
+
+// WithCSSInlining inlines the <style> rules of matching views into the style
+// attribute of the elements they select, as email clients require. It is an
+// after render transformation applied before after render hooks, and only to
+// views whose normalized name matches one of the patterns, with the same
+// semantics as WithUncacheableViews (e.g. "emails/*").
+//
+// Type, id and class selectors joined by descendant or child combinators are
+// inlined. Other rules, such as @media queries and selectors with pseudo
+// classes, are kept in a <style> block in the head. Style blocks marked with
+// data-inline="false" are left untouched.
+func WithCSSInlining(patterns ...string) Options {
+	return func(opt *option) {
+		for _, pattern := range patterns {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				opt.inlineCSS = append(opt.inlineCSS, toSlash(pattern))
+			}
+		}
+	}
+}
+
+// WithMaxPartials fails a render once it executes more than n partials through
+// include, require and relInclude, counting the view and its layout together,
+// e.g. to catch runaway recursive includes. Zero or less means no limit.
+func WithMaxPartials(n int) Options {
+	return func(opt *option) {
+		opt.maxPartials = max(n, 0)
+	}
+}
+
+// WithLogger sets the logger the engine reports load completion, development
+// reloads, cache misses and render errors to. By default nothing is logged.
+// The *slog.Logger type satisfies the Logger interface.
+func WithLogger(l Logger) Options {
+	return func(opt *option) {
+		opt.logger = l
+	}
+}
+
+// WithClock sets the clock used by time-relative pipes such as "humanizeTime"
+// and by output cache expiry. Default is time.Now.
+func WithClock(now func() time.Time) Options {
+	return func(opt *option) {
+		if now != nil {
+			opt.now = now
+		}
+	}
+}
+
+// WithTimeZone adds a "date" pipe formatting times in loc with a Go layout.
+// A nil loc formats in UTC. With WithTemplateFuncContext, the "timezone"
+// scope value overrides loc per render, as a *time.Location or an IANA zone
+// name such as "Europe/Berlin". "humanizeTime" is relative to the engine
+// clock and renders the same in every zone.
+//
+// code block:
+//
+//	{{ date .CreatedAt "Jan 2, 2006 15:04 MST" }}
+func WithTimeZone(loc *time.Location) Options {
+	return func(opt *option) {
+		if loc == nil {
+			loc = time.UTC
+		}
+		opt.timeZone = loc
+	}
+}
+
+// WithTrustedPipes fails Load when a registered pipe returns trusted content,
+// such as template.HTML or template.JS, without being listed in names.
+func WithTrustedPipes(names ...string) Options {
+	return func(opt *option) {
+		opt.auditPipes = true
+		opt.trustedPipes = append(opt.trustedPipes, names...)
+	}
+}
+
+// WithSandboxPipes allowlists registered pipes for RenderSandboxed.
+//
+// Sandboxed sources never get the built-in "view", "exists", "include",
+// "require" and "relInclude" pipes, so they cannot pull in partials or files.
+// Only the allowlisted pipes and the text/template builtins (such as len,
+// index and printf) are available.
+func WithSandboxPipes(names ...string) Options {
+	return func(opt *option) {
+		opt.sandboxPipes = append(opt.sandboxPipes, names...)
+	}
+}
+
+// WithSandboxTemplates allowlists shared templates (partials, macros or their
+// defines) that sandboxed sources may reference with {{ template }}. Any other
+// reference to a template not defined in the source itself is rejected.
+func WithSandboxTemplates(names ...string) Options {
+	return func(opt *option) {
+		opt.sandboxTemplates = append(opt.sandboxTemplates, names...)
+	}
+}
+
+// WithViewPipeName renames the layout "view" pipe, e.g. to avoid a collision
+// with an existing helper. Default is "view".
+func WithViewPipeName(name string) Options {
+	return WithBuiltinPipeName("view", name)
+}
+
+// WithBuiltinPipeName renames one of the built-in "view", "exists", "include",
+// "require" or "relInclude" pipes. Unknown built-in names are ignored.
+func WithBuiltinPipeName(builtin, name string) Options {
+	name = strings.TrimSpace(name)
+	return func(opt *option) {
+		switch builtin {
+		case "view", "exists", "include", "require", "relInclude":
+			if name == "" {
+				return
+			}
+			if opt.pipeNames == nil {
+				opt.pipeNames = make(map[string]string)
+			}
+			opt.pipeNames[builtin] = name
+		}
+	}
+}
+
 // WithPipes registers a custom function for use in templates.
 func WithPipes(name string, fn any) Options {
 	name = strings.TrimSpace(name)
@@ -132,19 +585,74 @@ func WithRegexpFmtPipe() Options {
 	}
 }
 
-// WithJSONPipe adds a "toJson" pipe to convert data to JSON strings.
+// WithJSONPipe adds a "toJson" pipe to convert data to JSON strings and a
+// "fromJson" pipe to parse JSON strings back into values.
+//
+// By default the "toJson" result is a plain string, which is quoted as a
+// JavaScript string inside <script> blocks. Use WithEscapeJSONInHTML to embed
+// it as a JavaScript value instead.
+//
+// "fromJson" accepts a string or []byte and returns map[string]any for
+// objects, []any for arrays, float64 for numbers, and string, bool or nil for
+// the other JSON values, so the result can be used with range and index.
+// Invalid JSON fails the render.
+//
+// code block:
+//
+//	{{ range $k, $v := fromJson .Settings }}{{ $k }}={{ $v }}{{ end }}
+//	{{ index (fromJson .Tags) 0 }}
 func WithJSONPipe() Options {
 	return func(opt *option) {
-		opt.Pipes["toJson"] = func(data any) (string, error) {
-			res, err := json.Marshal(data)
-			if err != nil {
-				return "", err
+		opt.Pipes["toJson"] = toJSON
+		opt.Pipes["fromJson"] = func(data any) (any, error) {
+			var raw []byte
+			switch v := data.(type) {
+			case string:
+				raw = []byte(v)
+			case []byte:
+				raw = v
+			default:
+				return nil, fmt.Errorf("fromJson expects string, got %T", data)
+			}
+
+			var res any
+			if err := json.Unmarshal(raw, &res); err != nil {
+				return nil, err
 			}
-			return string(res), nil
+			return res, nil
 		}
 	}
 }
 
+// toJSON is the "toJson" pipe registered by WithJSONPipe.
+func toJSON(data any) (string, error) {
+	res, err := json.Marshal(underlyingValue(data))
+	return string(res), err
+}
+
+// toTrustedJSON replaces toJSON when WithEscapeJSONInHTML is set. Its result
+// type is declared, so WithTrustedPipes can audit it.
+func toTrustedJSON(data any) (template.JS, error) {
+	res, err := json.Marshal(underlyingValue(data))
+	return template.JS(res), err
+}
+
+// WithEscapeJSONInHTML makes the "toJson" pipe return its output as trusted
+// template.JS. With WithTrustedPipes, "toJson" must then be listed. Inside <script> blocks the JSON is emitted as a JavaScript value
+// instead of a quoted string, and inside HTML attributes it is entity-escaped
+// so quotes cannot break the attribute. The JSON encoder escapes "<", ">" and
+// "&", so the output cannot close a script block.
+//
+// code block:
+//
+//	<div data-config="{{ toJson .Config }}"></div>
+//	<script>const config = {{ toJson .Config }};</script>
+func WithEscapeJSONInHTML() Options {
+	return func(opt *option) {
+		opt.escapeJSON = true
+	}
+}
+
 // WithDictPipe adds a "dict" pipe to create a map from key-value pairs.
 //
 // code block:
@@ -173,6 +681,85 @@ func WithDictPipe() Options {
 	}
 }
 
+// WithMergeDataPipe adds a "mergeData" pipe that shallow merges maps,
+// contexts and structs into a new map[string]any, e.g. to pass the current
+// data with extra keys to a partial. Later arguments override earlier ones on
+// key collision, nil arguments are skipped, and structs contribute their
+// exported fields by field name. Other argument types fail the render.
+//
+// code block:
+//
+//	{{ include "@partials/card" (mergeData . (dict "featured" true)) }}
+func WithMergeDataPipe() Options {
+	return func(opt *option) {
+		opt.Pipes["mergeData"] = mergeData
+	}
+}
+
+// WithSlicePipes adds "first", "last", "rest" and "slice" pipes for slices,
+// arrays and strings. "first" and "last" return nil for empty collections,
+// "rest" returns all items but the first, and "slice coll start [end]"
+// returns the sub-slice from start up to end, or to the end when omitted.
+// Out of range bounds are clamped to the collection instead of failing the
+// render. Strings are handled by runes. This "slice" replaces the built-in
+// one, which fails on out of range bounds.
+//
+// code block:
+//
+//	{{ with first .Posts }}<h1>{{ .Title }}</h1>{{ end }}
+//	{{ range slice .Posts 1 4 }}<li>{{ .Title }}</li>{{ end }}
+func WithSlicePipes() Options {
+	return func(opt *option) {
+		opt.Pipes["first"] = func(coll any) (any, error) {
+			return itemOf("first", coll, 0)
+		}
+		opt.Pipes["last"] = func(coll any) (any, error) {
+			return itemOf("last", coll, -1)
+		}
+		opt.Pipes["rest"] = func(coll any) (any, error) {
+			return sliceOf("rest", coll, 1, math.MaxInt)
+		}
+		opt.Pipes["slice"] = func(coll any, start int, end ...int) (any, error) {
+			if len(end) > 1 {
+				return nil, fmt.Errorf("slice expects at most 2 bounds")
+			}
+			if len(end) == 0 {
+				return sliceOf("slice", coll, start, math.MaxInt)
+			}
+			return sliceOf("slice", coll, start, end[0])
+		}
+	}
+}
+
+// WithDebugPipe adds a "dump" pipe that pretty-prints its argument as
+// indented JSON in a <pre> block, falling back to Go syntax for values that
+// cannot be marshaled. It only prints in development mode and renders
+// nothing otherwise, so data is never leaked in production.
+//
+// code block:
+//
+//	{{ dump . }}
+func WithDebugPipe() Options {
+	return func(opt *option) {
+		opt.Pipes["dump"] = func(v any) template.HTML {
+			if !opt.Dev {
+				return ""
+			}
+
+			v = underlyingValue(v)
+			var buf strings.Builder
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+			enc.SetIndent("", "  ")
+			out := fmt.Sprintf("%#v", v)
+			if err := enc.Encode(v); err == nil {
+				out = strings.TrimSuffix(buf.String(), "\n")
+			}
+			return template.HTML("<pre>" + template.HTMLEscapeString(out) + "</pre>")
+		}
+	}
+}
+
 // WithIsSetPipe adds an "isSet" pipe to check if a field exists in a map.
 //
 // code block:
@@ -215,26 +802,9 @@ func WithAlterPipe() Options {
 func WithDeepAlterPipe() Options {
 	return func(opt *option) {
 		opt.Pipes["deepAlter"] = func(val, alt any) any {
-			if val == nil {
+			if isEmpty(val) {
 				return alt
 			}
-			v := reflect.ValueOf(val)
-			switch v.Kind() {
-			case reflect.String, reflect.Slice, reflect.Map, reflect.Chan:
-				if v.Len() == 0 {
-					return alt
-				}
-			case reflect.Ptr, reflect.Interface:
-				if v.IsNil() {
-					return alt
-				}
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
-				reflect.Float32, reflect.Float64:
-				if v.IsZero() {
-					return alt
-				}
-			}
 			return val
 		}
 	}
@@ -253,3 +823,126 @@ func WithBrPipe() Options {
 		}
 	}
 }
+
+// WithSafePipes adds "isSafe" and "ensureSafe" pipes for composing trusted
+// HTML. "isSafe" reports whether a value is already template.HTML and
+// "ensureSafe" returns it unchanged if so, or escapes it otherwise.
+//
+// code block:
+//
+//	{{ $card := include "@partials/card" . }}
+//	{{ ensureSafe $card }} <!-- rendered once, never double-escaped -->
+func WithSafePipes() Options {
+	return func(opt *option) {
+		opt.Pipes["isSafe"] = IsSafe
+		opt.Pipes["ensureSafe"] = EnsureSafe
+	}
+}
+
+// WithFormPipes adds "errorFor", "hasError" and "oldValue" pipes for rendering
+// form validation errors and repopulating submitted values. Errors are read
+// from a map[string][]string and values from a map[string][]string
+// (e.g. url.Values), map[string]string or map[string]any. Missing fields
+// render as an empty string or false.
+//
+// code block:
+//
+//	<input name="email" value="{{ oldValue .values "email" }}"
+//		class="{{ if hasError .errors "email" }}invalid{{ end }}">
+//	<small>{{ errorFor .errors "email" }}</small>
+func WithFormPipes() Options {
+	return func(opt *option) {
+		opt.Pipes["errorFor"] = func(errors map[string][]string, field string) string {
+			if messages := errors[field]; len(messages) > 0 {
+				return messages[0]
+			}
+			return ""
+		}
+		opt.Pipes["hasError"] = func(errors map[string][]string, field string) bool {
+			return len(errors[field]) > 0
+		}
+		opt.Pipes["oldValue"] = func(values any, field string) any {
+			switch v := underlyingValue(values).(type) {
+			case map[string][]string:
+				if len(v[field]) > 0 {
+					return v[field][0]
+				}
+			case map[string]string:
+				return v[field]
+			case map[string]any:
+				if val, ok := v[field]; ok && val != nil {
+					return val
+				}
+			}
+			return ""
+		}
+	}
+}
+
+// WithHumanizePipes adds "humanizeBytes", "humanizeTime" and "ordinal" pipes
+// for human-friendly formatting. "humanizeBytes" uses binary (1024) steps by
+// default and SI (1000) steps when its optional flag is true. "humanizeTime"
+// accepts time.Time or *time.Time and is relative to the engine clock.
+//
+// code block:
+//
+//	{{ humanizeBytes 1536 }}      <!-- 1.5 KB -->
+//	{{ humanizeBytes 1536 true }} <!-- 1.5 kB -->
+//	{{ humanizeTime .CreatedAt }} <!-- 3 minutes ago -->
+//	{{ ordinal 21 }}              <!-- 21st -->
+func WithHumanizePipes() Options {
+	return func(opt *option) {
+		opt.Pipes["humanizeBytes"] = func(size any, si ...bool) (string, error) {
+			v, err := toFloat(size)
+			if err != nil {
+				return "", err
+			}
+			return humanizeBytes(v, len(si) > 0 && si[0]), nil
+		}
+		opt.Pipes["humanizeTime"] = func(v any) (string, error) {
+			t, ok, err := toTime("humanizeTime", v)
+			if !ok {
+				return "", err
+			}
+			return humanizeTime(t, opt.now()), nil
+		}
+		opt.Pipes["ordinal"] = func(n any) (string, error) {
+			v, err := toFloat(n)
+			if err != nil {
+				return "", err
+			}
+			return ordinal(int64(v)), nil
+		}
+	}
+}
+
+// WithRoutePipes adds "isActive" and "isExact" pipes for navigation menus. The
+// current request path is read from the render data under currentPathKey.
+// "isActive" matches the target path and any nested path, "isExact" only the
+// target path. Query strings, fragments and trailing slashes are ignored.
+//
+// code block:
+//
+//	<a href="/blog" class="{{ if isActive "/blog" }}active{{ end }}">Blog</a>
+func WithRoutePipes(currentPathKey string) Options {
+	currentPathKey = strings.TrimSpace(currentPathKey)
+	return func(opt *option) {
+		opt.routeKey = currentPathKey
+	}
+}
+
+// WithTemplateFuncContext adds a "scope" pipe exposing per-render values such
+// as a CSP nonce, the request locale or a CSRF token. The values are read from
+// the render data under scopeKey, as a map[string]any or Context, and are
+// never shared between concurrent renders. Missing keys resolve to nil.
+//
+// code block:
+//
+//	data := template.Ctx().Add("$scope", template.Ctx().Add("nonce", nonce))
+//	<script nonce="{{ scope "nonce" }}">...</script>
+func WithTemplateFuncContext(scopeKey string) Options {
+	scopeKey = strings.TrimSpace(scopeKey)
+	return func(opt *option) {
+		opt.scopeKey = scopeKey
+	}
+}
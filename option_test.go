@@ -0,0 +1,79 @@
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONPipeOutputUnchanged(t *testing.T) {
+	// toJson returned (string, error) before it could emit template.JS
+	legacy := func(data any) (string, error) {
+		res, err := json.Marshal(data)
+		return string(res), err
+	}
+
+	source := `<div data-config="{{ %s .C }}"></div><script>const c = {{ %s .C }};</script><p>{{ %s .C }}</p>`
+	tpl := newTestEngine(t, map[string]string{
+		"views/current.tpl": strings.ReplaceAll(source, "%s", "toJson"),
+		"views/legacy.tpl":  strings.ReplaceAll(source, "%s", "legacyJson"),
+	}, WithJSONPipe(), WithPipes("legacyJson", legacy))
+
+	data := map[string]any{"C": map[string]any{"a": `"</script><b>&'`}}
+	var current, previous bytes.Buffer
+	if err := tpl.Render(&current, "current", data); err != nil {
+		t.Fatal(err)
+	}
+	if err := tpl.Render(&previous, "legacy", data); err != nil {
+		t.Fatal(err)
+	}
+	if current.String() != previous.String() {
+		t.Fatalf("toJson output changed:\n got %s\nwant %s", current.String(), previous.String())
+	}
+}
+
+func TestEscapeJSONInHTML(t *testing.T) {
+	tpl := newTestEngine(t, map[string]string{
+		"views/attr.tpl":   `<div data-config="{{ toJson .C }}"></div>`,
+		"views/script.tpl": `<script>const c = {{ toJson .C }};</script>`,
+	}, WithJSONPipe(), WithEscapeJSONInHTML())
+
+	data := map[string]any{"C": map[string]any{"a": `"</script><b>&'`}}
+	tests := []struct {
+		view, want string
+	}{
+		{"attr", `<div data-config="{&#34;a&#34;:&#34;\&#34;\u003c/script\u003e\u003cb\u003e\u0026&#39;&#34;}"></div>`},
+		{"script", `<script>const c = {"a":"\"\u003c/script\u003e\u003cb\u003e\u0026'"};</script>`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := tpl.Render(&buf, tt.view, data); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.view, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestTrustedJSONPipeAudit(t *testing.T) {
+	files := map[string]string{"views/home.tpl": `{{ toJson .C }}`}
+	tests := []struct {
+		name    string
+		options []Options
+		fail    bool
+	}{
+		{"plain", []Options{WithJSONPipe(), WithTrustedPipes()}, false},
+		{"escaped", []Options{WithJSONPipe(), WithEscapeJSONInHTML(), WithTrustedPipes()}, true},
+		{"escaped trusted", []Options{WithEscapeJSONInHTML(), WithJSONPipe(), WithTrustedPipes("toJson")}, false},
+	}
+	for _, tt := range tests {
+		err := newTestEngine(t, files, tt.options...).Load()
+		if tt.fail && err == nil {
+			t.Errorf("%s: expected the audit to fail", tt.name)
+		} else if !tt.fail && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
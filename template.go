@@ -5,18 +5,31 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"maps"
+	"mime"
 	"os"
+	"path"
+	"reflect"
 	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template/parse"
+	"time"
 
 	"github.com/go-universal/fs"
 )
 
 // Template defines the interface for template operations.
 type Template interface {
-	// Load loads shared templates from the filesystem.
+	// Load loads shared templates from the filesystem. Calling it is
+	// optional: templates are loaded lazily on first use.
 	Load() error
 
+	// Reload discards the loaded state so the next call reloads
+	// shared templates from the filesystem.
+	Reload()
+
 	// Exists checks if a template exists.
 	Exists(name string) (bool, error)
 
@@ -24,44 +37,297 @@ type Template interface {
 	// the given view, data, and optional layouts.
 	Render(w io.Writer, view string, data interface{}, layouts ...string) error
 
+	// ExecuteNamed compiles the view, layout and partials like Render and
+	// executes the templateName template of the compiled set instead of the
+	// view, e.g. a define block of the view or its layout. The "view" pipe is
+	// not available to it.
+	ExecuteNamed(w io.Writer, view, templateName string, data any, layouts ...string) error
+
+	// RenderTee renders like Render, writing the output to both w and capture
+	// in a single pass, e.g. to store it for auditing. On error both sinks
+	// hold the same partial output, except when writing to w fails: capture
+	// then stops at the last chunk w accepted.
+	RenderTee(w io.Writer, capture *bytes.Buffer, view string, data any, layouts ...string) error
+
+	// RenderSandboxed renders an untrusted template source in isolation.
+	// Only allowlisted pipes and shared templates are available; the
+	// built-in view, exists, include, require and relInclude pipes are not.
+	RenderSandboxed(w io.Writer, source string, data any) error
+
+	// RenderPartial renders a registered partial directly, e.g. for fragment
+	// responses. The name is relative to the partials path, with or without
+	// the partial prefix. This is the intentional counterpart of the guard
+	// rejecting partials in Render.
+	RenderPartial(w io.Writer, name string, data any) error
+
 	// Compile compiles a template with the given name, layout, and data.
 	Compile(name, layout string, data any, partials ...string) ([]byte, error)
+
+	// CompileHTML compiles a template like Compile and returns the output as
+	// trusted HTML, so it can be passed as data and embedded in another view
+	// without being escaped again. The output is trusted because it was
+	// already escaped by its own render pass.
+	CompileHTML(name, layout string, data any, partials ...string) (template.HTML, error)
+
+	// CompileGzip compiles a template like Compile and returns the gzipped output.
+	CompileGzip(name, layout string, data any, partials ...string) ([]byte, error)
+
+	// RenderWithInlineLayout renders a view like Render, using layoutSource
+	// parsed on the fly as its layout. The layout can use the "view" pipe
+	// to inject the child view.
+	RenderWithInlineLayout(w io.Writer, view string, data any, layoutSource string, partials ...string) error
+
+	// ContentType returns the MIME type implied by the view name, e.g.
+	// "application/json" for "api/user.json".
+	ContentType(name string) string
+
+	// Stats returns a snapshot of the compiled template cache counters.
+	Stats() CacheStats
+
+	// ContentSecurityReport returns the CSP violations found in the latest
+	// render of each view when content security reporting is enabled.
+	ContentSecurityReport() []CSPViolation
+
+	// LastRenderProfile returns the per-template execution durations of
+	// the most recent render when profiling is enabled.
+	LastRenderProfile() map[string]time.Duration
+
+	// Dependencies returns the names of templates referenced by the view
+	// and its optional layouts through include, require, relInclude and
+	// template actions.
+	Dependencies(name string, layouts ...string) ([]string, error)
+
+	// Validate compiles and escapes every view and checks that the templates
+	// referenced through template actions and require resolve against the
+	// loaded partials and defines, returning all problems joined into one
+	// error. Include references are optional by design: unresolved ones and
+	// dynamic references are logged as warnings.
+	Validate() error
+
+	// ValidateReport checks the views like Validate and returns the status
+	// of each template file instead of a joined error, e.g. for an admin
+	// page. Shared files are parsed one by one, so a broken partial is
+	// reported even when loading fails. Compiled views are not cached, but
+	// like Render it loads the templates when needed, which clears the
+	// caches, bumps Version and runs the after load hooks.
+	ValidateReport() Report
+
+	// Check compiles and escapes the view with its layout and partials like
+	// Render without executing it, returning resolution, parse, missing
+	// reference and escaping errors. Escaping errors of templates included
+	// by a computed name only show when the view executes. The compiled
+	// template is not cached.
+	Check(name, layout string, partials ...string) error
+
+	// Version returns a token incremented every time templates are loaded
+	// successfully. Compare tokens to detect reloads without locking.
+	Version() uint64
+
+	// Namespace returns a lightweight engine layered over this one, e.g. for
+	// a tenant. Templates found in overlay override the parent templates of
+	// the same path; everything else, including compiled templates, is shared
+	// with the parent.
+	Namespace(overlay fs.FlexibleFS) Template
+}
+
+// CacheStats describes the state of the compiled template cache.
+type CacheStats struct {
+	// Entries is the number of cached compiled templates.
+	Entries int
+
+	// Hits is the number of renders served from the cache since start.
+	Hits uint64
+
+	// Misses is the number of renders that had to compile their template.
+	Misses uint64
+
+	// Bytes approximates the cache memory as the total size of the
+	// template sources parsed into cached entries.
+	Bytes int64
 }
 
 type tplEngine struct {
-	option    option
-	fs        fs.FlexibleFS
-	base      *template.Template
-	templates map[string]*template.Template
-	partialRx *regexp.Regexp
-	mutex     sync.RWMutex
+	option       option
+	fs           fs.FlexibleFS
+	base         *template.Template
+	templates    map[string]*cachedTemplate
+	partialRx    *regexp.Regexp
+	macroRx      *regexp.Regexp
+	sources      map[string]*partialSource
+	partialNames []string
+	csp          cspReport
+	outputs      *outputCache
+	failures     *failureCache
+	mutex        sync.RWMutex
+	lazy         atomic.Pointer[lazyLoad]
+	generation   atomic.Uint64
+	version      atomic.Uint64
+	profile      atomic.Pointer[map[string]time.Duration]
+
+	// Namespace overlay state, see Namespace and pinned
+	pin       bool
+	parent    *tplEngine
+	parentGen atomic.Uint64
+	overrides map[string]bool
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	bytes  atomic.Int64
 }
 
 // New creates a new Template instance with the provided filesystem and options.
 func New(fs fs.FlexibleFS, options ...Options) Template {
 	// Initialize default options
 	option := &option{
-		root:       ".",
-		partials:   "",
-		extension:  ".tpl",
-		leftDelim:  "{{",
-		rightDelim: "}}",
-		Dev:        false,
-		Cache:      false,
-		Pipes:      make(template.FuncMap),
+		root:          ".",
+		partials:      "",
+		partialPrefix: "@partials/",
+		extension:     ".tpl",
+		leftDelim:     "{{",
+		rightDelim:    "}}",
+		Dev:           false,
+		Cache:         false,
+		Pipes:         make(template.FuncMap),
+		now:           time.Now,
 	}
 	for _, opt := range options {
 		opt(option)
 	}
 
+	// Emit trusted JSON unless toJson was replaced by a custom pipe
+	if pipe, ok := option.Pipes["toJson"].(func(any) (string, error)); ok && option.escapeJSON &&
+		reflect.ValueOf(pipe).Pointer() == reflect.ValueOf(toJSON).Pointer() {
+		option.Pipes["toJson"] = toTrustedJSON
+	}
+
+	// Resolve global template paths against the final root
+	for i, name := range option.globals {
+		option.globals[i] = toPath(name, option.root, option.extension)
+	}
+	for i, name := range option.autoIncludes {
+		option.autoIncludes[i] = toPath(name, option.root, option.extension)
+	}
+
 	// Create and return the template engine
-	return &tplEngine{
+	engine := &tplEngine{
 		option: *option,
 		fs:     fs,
 	}
+	engine.lazy.Store(&lazyLoad{})
+
+	// Generate partial and macro patterns, paths are quoted so
+	// patterns always compile. They never change after creation
+	// and are read by concurrent renders without locking.
+	if option.partials != "" {
+		engine.partialRx = regexp.MustCompile(extPattern(option.partials, option.extension))
+	}
+	if option.macros != "" {
+		engine.macroRx = regexp.MustCompile(extPattern(option.macros, option.extension))
+	}
+
+	if option.outputCacheSize > 0 {
+		engine.outputs = newOutputCache(option.outputCacheSize, option.outputCacheTTL, option.now)
+	}
+	if option.errorCooldown > 0 && !option.Dev {
+		engine.failures = newFailureCache(failureCacheSize, option.errorCooldown, option.now)
+	}
+	return engine
 }
 
 func (t *tplEngine) Load() error {
+	l := &lazyLoad{}
+	t.lazy.Store(l)
+	return t.loadOnce(l)
+}
+
+func (t *tplEngine) Reload() {
+	t.lazy.Store(&lazyLoad{})
+}
+
+// lazyLoad tracks a one-time load of the shared templates.
+type lazyLoad struct {
+	once sync.Once
+	err  error
+}
+
+// ensureLoaded loads the shared templates on first use. In development
+// mode templates are reloaded on every call instead.
+func (t *tplEngine) ensureLoaded() error {
+	if t.pin {
+		return nil
+	}
+	if t.option.Dev {
+		if t.option.logger != nil {
+			t.option.logger.Debug("reloading templates in development mode")
+		}
+		return t.Load()
+	}
+
+	// Reload namespaces after their parent reloaded
+	if t.parent != nil {
+		if err := t.parent.ensureLoaded(); err != nil {
+			return err
+		}
+		if t.parent.generation.Load() != t.parentGen.Load() {
+			t.Reload()
+		}
+	}
+	return t.loadOnce(t.lazy.Load())
+}
+
+// loadOnce loads the shared templates once for l and runs the after load
+// hooks within the once, so every caller waiting on l sees a failing hook.
+// Hooks receive an engine pinned to the loaded templates, so they can call
+// back into the engine without waiting on l. A failed load is retried on
+// the next call.
+func (t *tplEngine) loadOnce(l *lazyLoad) error {
+	loaded := false
+	l.once.Do(func() {
+		if l.err = t.load(); l.err != nil {
+			return
+		}
+
+		// Run after load hooks outside of the lock
+		if len(t.option.afterLoad) > 0 {
+			pinned := t.pinned()
+			for _, hook := range t.option.afterLoad {
+				if l.err = hook(pinned); l.err != nil {
+					return
+				}
+			}
+		}
+		t.version.Add(1)
+		loaded = true
+	})
+
+	if l.err != nil {
+		t.lazy.CompareAndSwap(l, &lazyLoad{})
+		return l.err
+	}
+
+	// Notify reload callbacks outside of the once
+	if loaded {
+		for _, fn := range t.option.onReload {
+			fn()
+		}
+	}
+
+	return nil
+}
+
+// load reads and registers the shared templates under the write lock.
+func (t *tplEngine) load() error {
+	if t.parent != nil {
+		return t.loadNamespace()
+	}
+
+	// Audit pipes emitting trusted content
+	if t.option.auditPipes {
+		if err := auditPipes(t.option.Pipes, t.option.trustedPipes); err != nil {
+			return err
+		}
+	}
+
 	var err error
 
 	// Safe race condition
@@ -69,27 +335,21 @@ func (t *tplEngine) Load() error {
 	defer t.mutex.Unlock()
 
 	// Initialize
-	t.templates = make(map[string]*template.Template)
+	t.generation.Add(1)
+	t.templates = make(map[string]*cachedTemplate)
+	t.bytes.Store(0)
+	if t.outputs != nil {
+		t.outputs.clear()
+	}
+	if t.failures != nil {
+		t.failures.clear()
+	}
 	t.base = template.New("").
 		Delims(t.option.leftDelim, t.option.rightDelim).
-		Funcs(t.option.Pipes)
+		Funcs(t.decorate(t.option.Pipes))
 
 	// Add built-in pipes
-	viewPipe(t.base, nil)
-	existsPipe(t.base)
-	includePipe(t.base)
-	requirePipe(t.base)
-
-	// Generate partial pattern
-	if t.option.partials != "" {
-		t.partialRx, err = regexp.Compile(extPattern(
-			t.option.partials,
-			t.option.extension,
-		))
-		if err != nil {
-			return err
-		}
-	}
+	t.registerPipes(t.base, &renderState{})
 
 	// Read files from fs
 	files, err := t.fs.Lookup(
@@ -100,20 +360,38 @@ func (t *tplEngine) Load() error {
 		return err
 	}
 
+	// Load macros
+	if t.option.macros != "" {
+		if err := t.loadMacros(files); err != nil {
+			return err
+		}
+	}
+
 	// Load partials
+	sources := make(map[string]*partialSource)
+	t.partialNames = nil
 	if t.option.partials != "" {
 		for _, file := range files {
 			// Skip non partials
-			if !t.partialRx.MatchString(file) {
+			if !t.partialRx.MatchString(toSlash(file)) {
 				continue
 			}
 
-			// Generate friendly name
+			// Generate friendly name, preserving nested directories
 			name := toName(file, t.option.partials, t.option.extension)
-			name = "@partials/" + name
+			t.partialNames = append(t.partialNames, name)
+			name = t.option.partialPrefix + name
+
+			// Reuse parse trees of unchanged partials
+			if t.option.sourceCache {
+				if err := t.loadCachedPartial(file, name, sources); err != nil {
+					return err
+				}
+				continue
+			}
 
 			// Read file
-			content, err := t.fs.ReadFile(file)
+			content, err := t.readSource(file)
 			if err != nil {
 				return err
 			}
@@ -125,18 +403,194 @@ func (t *tplEngine) Load() error {
 		}
 	}
 
+	// Drop cached sources of removed partials
+	if t.option.sourceCache {
+		t.sources = sources
+	}
+
+	// Register short aliases of nested partials
+	if t.option.partialAliases {
+		if err := t.loadAliases(t.partialNames); err != nil {
+			return err
+		}
+	}
+
+	// Load global templates
+	for _, file := range t.option.globals {
+		name := toName(file, t.option.root, t.option.extension)
+
+		content, err := t.readSource(file)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s global template not found", file)
+		} else if err != nil {
+			return err
+		}
+
+		_, err = t.base.New(name).Parse(string(content))
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.option.logger != nil {
+		t.option.logger.Debug("templates loaded", "count", len(t.base.Templates()))
+	}
+
 	return nil
 }
 
-func (t *tplEngine) Exists(name string) (bool, error) {
-	// Reload on development mode
-	if t.option.Dev {
-		if err := t.Load(); err != nil {
-			return false, err
+// partialSource holds the parse trees of a partial file and
+// the file state they were parsed from.
+type partialSource struct {
+	modTime time.Time
+	size    int64
+	trees   map[string]*parse.Tree
+}
+
+// loadCachedPartial registers the partial file into the base template,
+// reusing its cached parse trees when the file modification time and
+// size are unchanged. Caller must hold the write lock.
+func (t *tplEngine) loadCachedPartial(file, name string, sources map[string]*partialSource) error {
+	f, err := t.fs.Open(file)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	source, ok := t.sources[file]
+	if !ok || !source.modTime.Equal(info.ModTime()) || source.size != info.Size() {
+		// Read file
+		content, err := t.readSource(file)
+		if err != nil {
+			return err
+		}
+
+		// Parse in isolation to collect the file trees
+		tpl := template.New(name).
+			Delims(t.option.leftDelim, t.option.rightDelim).
+			Funcs(t.decorate(t.option.Pipes))
+		t.registerPipes(tpl, &renderState{})
+		if _, err := tpl.Parse(string(content)); err != nil {
+			return err
+		}
+
+		source = &partialSource{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			trees:   make(map[string]*parse.Tree),
+		}
+		for _, item := range tpl.Templates() {
+			if item.Tree != nil {
+				source.trees[item.Name()] = item.Tree
+			}
 		}
 	}
+	sources[file] = source
+
+	// Trees are shared without copying: the base is never executed
+	// and every render clone copies the trees it escapes.
+	for name, tree := range source.trees {
+		if _, err := t.base.AddParseTree(name, tree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadAliases registers the last path segment of nested partials as a short
+// alias, e.g. "@partials/input" for "@partials/ui/forms/input". An alias
+// shared by two partials is skipped, an alias equal to another partial name
+// is an error. Caller must hold the write lock.
+func (t *tplEngine) loadAliases(names []string) error {
+	owners := make(map[string][]string)
+	for _, name := range names {
+		alias := path.Base(name)
+		if alias == name {
+			continue
+		}
+		if contains(names, alias) {
+			return fmt.Errorf("%s partial alias of %s collides with partial %s", alias, name, alias)
+		}
+		owners[alias] = append(owners[alias], name)
+	}
+
+	for alias, partials := range owners {
+		// Skip ambiguous alias
+		if len(partials) > 1 {
+			if t.option.logger != nil {
+				t.option.logger.Warn("ambiguous partial alias skipped", "alias", alias, "partials", partials)
+			}
+			continue
+		}
+
+		tpl := t.base.Lookup(t.option.partialPrefix + partials[0])
+		if tpl == nil || tpl.Tree == nil {
+			continue
+		}
+		if _, err := t.base.AddParseTree(t.option.partialPrefix+alias, tpl.Tree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadMacros parses macro files into the base template so their define
+// blocks are callable from every view. Caller must hold the write lock.
+func (t *tplEngine) loadMacros(files []string) error {
+	defined := make(map[string]string)
+	for _, file := range files {
+		// Skip non macros
+		if !t.macroRx.MatchString(toSlash(file)) {
+			continue
+		}
+
+		// Read file
+		content, err := t.readSource(file)
+		if err != nil {
+			return err
+		}
+
+		// Detect duplicate defines across macro files
+		trees := make(map[string]*parse.Tree)
+		tree := parse.New(file)
+		tree.Mode = parse.SkipFuncCheck
+		if _, err := tree.Parse(string(content), t.option.leftDelim, t.option.rightDelim, trees); err != nil {
+			return err
+		}
+		for name := range trees {
+			if name == file {
+				continue
+			}
+			if prev, ok := defined[name]; ok {
+				return fmt.Errorf("%s macro defined in both %s and %s", name, prev, file)
+			}
+			defined[name] = file
+		}
+
+		name := toName(file, t.option.macros, t.option.extension)
+		_, err = t.base.New("macros::" + name).Parse(string(content))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *tplEngine) Exists(name string) (bool, error) {
+	// Load on first use or reload on development mode
+	if err := t.ensureLoaded(); err != nil {
+		return false, err
+	}
 
 	// Resolve and normalize view
+	if err := t.checkName(name); err != nil {
+		return false, err
+	}
 	view := toPath(name, t.option.root, t.option.extension)
 	viewId := toName(view, t.option.root, t.option.extension)
 	key := toKey(viewId)
@@ -151,7 +605,7 @@ func (t *tplEngine) Exists(name string) (bool, error) {
 	}
 
 	// Check if template exists in the filesystem
-	if _, err := t.fs.ReadFile(view); os.IsNotExist(err) {
+	if _, err := t.readFile(view); os.IsNotExist(err) {
 		return false, nil
 	} else if err != nil {
 		return false, err
@@ -160,143 +614,858 @@ func (t *tplEngine) Exists(name string) (bool, error) {
 	return true, nil
 }
 
-func (t *tplEngine) Render(w io.Writer, name string, data interface{}, layouts ...string) error {
-	var err error
+func (t *tplEngine) Render(w io.Writer, name string, data interface{}, layouts ...string) (err error) {
+	defer t.logError(name, &err)
 
-	// Reload on development mode
-	if t.option.Dev {
-		if err := t.Load(); err != nil {
-			return err
-		}
+	// Load on first use or reload on development mode
+	if err := t.ensureLoaded(); err != nil {
+		return err
 	}
 
-	// Resolve and normalize view
-	view := toPath(name, t.option.root, t.option.extension)
-	viewId := toName(view, t.option.root, t.option.extension)
+	// Resolve and normalize view, layout and partials
+	r, err := t.resolve(name, t.withLayout(name, data, layouts)...)
+	if err != nil {
+		return err
+	}
 
-	// Resolve and normalize layout and partials
-	layout := ""
-	layoutId := ""
-	partials := make([]string, 0)
-	partialsId := make([]string, 0)
-	if len(layouts) > 0 {
-		for i := range layouts {
-			if i == 0 {
-				layout = toPath(layouts[0], t.option.root, t.option.extension)
-				layoutId = toName(layout, t.option.root, t.option.extension)
-			} else if layouts[i] != "" {
-				name := toPath(layouts[i], t.option.root, t.option.extension)
-				id := toName(name, t.option.root, t.option.extension)
-				partials = append(partials, name)
-				partialsId = append(partialsId, id)
-			}
+	// Fail fast while a failed view cools down
+	if t.failures != nil {
+		if err := t.failures.get(r.key); err != nil {
+			return err
 		}
 	}
 
-	// Generate key
-	key := toKey(append([]string{viewId, layoutId}, partialsId...)...)
-
-	// Check partials render
-	if t.partialRx != nil && t.partialRx.MatchString(view) {
-		return fmt.Errorf("%s partial cannot render directly", view)
+	// Resolve Template
+	tpl, release, err := t.prepare(r, func(e *tplEngine) (*template.Template, error) {
+		return e.parse(r)
+	})
+	if err != nil {
+		t.fail(r.key, err)
+		return err
 	}
 
-	if layout != "" && t.partialRx != nil && t.partialRx.MatchString(layout) {
-		return fmt.Errorf("%s partial cannot render directly", layout)
+	if err := t.output(w, tpl, r, data); err != nil {
+		t.fail(r.key, err)
+		return err
 	}
+	release()
+	return nil
+}
 
-	for _, partial := range partials {
-		if t.partialRx != nil && t.partialRx.MatchString(partial) {
-			return fmt.Errorf("%s partial already loaded globally", layout)
-		}
+// fail records a compile failure of the template key when the error
+// cooldown is enabled. Read and execution errors, e.g. a missing view or a
+// pipe rejecting request data, are not remembered.
+func (t *tplEngine) fail(key string, err error) {
+	if t.failures != nil && isCompileError(err) {
+		t.failures.put(key, err)
 	}
+}
 
-	// Safe race condition
-	t.mutex.RLock()
-	defer t.mutex.RUnlock()
+func (t *tplEngine) ExecuteNamed(w io.Writer, name, templateName string, data any, layouts ...string) (err error) {
+	defer t.logError(name, &err)
 
-	// Resolve Template
-	tpl, ok := t.templates[key]
-	if !ok {
-		// Clone from base engine
-		tpl, err = t.base.Clone()
-		if err != nil {
-			return err
-		}
+	// Load on first use or reload on development mode
+	if err := t.ensureLoaded(); err != nil {
+		return err
+	}
 
-		// Read and parse view
-		if raw, err := t.fs.ReadFile(view); os.IsNotExist(err) {
-			return fmt.Errorf("%s template not found", view)
-		} else if err != nil {
-			return err
-		} else {
-			_, err := tpl.New("view::" + viewId).Parse(string(raw))
-			if err != nil {
-				return err
-			}
-		}
+	// Resolve and normalize view, layout and partials
+	r, err := t.resolve(name, t.withLayout(name, data, layouts)...)
+	if err != nil {
+		return err
+	}
 
-		// Read and parse layout
-		if layout != "" {
-			if raw, err := t.fs.ReadFile(layout); os.IsNotExist(err) {
-				return fmt.Errorf("%s layout template not found", layout)
-			} else if err != nil {
-				return err
-			} else {
-				_, err := tpl.New("layout::" + layoutId).Parse(string(raw))
-				if err != nil {
-					return err
-				}
-			}
-		}
+	// Resolve Template, sharing the compiled set of Render
+	tpl, release, err := t.prepare(r, func(e *tplEngine) (*template.Template, error) {
+		return e.parse(r)
+	})
+	if err != nil {
+		return err
+	}
 
-		for i := range partials {
-			if raw, err := t.fs.ReadFile(partials[i]); os.IsNotExist(err) {
-				return fmt.Errorf("%s partial template not found", partials[i])
-			} else if err != nil {
-				return err
-			} else {
-				_, err := tpl.New(partialsId[i]).Parse(string(raw))
-				if err != nil {
-					return err
-				}
-			}
-		}
+	if tpl.Lookup(templateName) == nil {
+		return fmt.Errorf("%s template not defined in %s", templateName, r.viewId)
+	}
 
-		// Store to cache
-		if !t.option.Dev && t.option.Cache {
-			t.templates[key] = tpl
-		}
+	// Execute the named template alone
+	named := *r
+	named.entry = templateName
+	named.layout = ""
+	if err := t.output(w, tpl, &named, data); err != nil {
+		return err
 	}
+	release()
+	return nil
+}
 
-	// Add built-in pipes
-	viewPipe(tpl, nil)
-	existsPipe(tpl)
-	includePipe(tpl)
-	requirePipe(tpl)
+func (t *tplEngine) RenderWithInlineLayout(w io.Writer, name string, data any, layoutSource string, partials ...string) (err error) {
+	defer t.logError(name, &err)
 
-	// Render
-	if layout == "" {
-		return tpl.ExecuteTemplate(w, "view::"+viewId, underlyingValue(data))
-	} else {
-		// Render child view to layout
-		var buf bytes.Buffer
-		err = tpl.ExecuteTemplate(&buf, "view::"+viewId, underlyingValue(data))
-		if err != nil {
-			return err
-		}
-		viewPipe(tpl, buf.Bytes())
+	// Load on first use or reload on development mode
+	if err := t.ensureLoaded(); err != nil {
+		return err
+	}
 
-		return tpl.ExecuteTemplate(w, "layout::"+layoutId, underlyingValue(data))
+	// Resolve and normalize view and partials
+	r, err := t.resolve(name, append([]string{""}, partials...)...)
+	if err != nil {
+		return err
 	}
-}
 
-func (t *tplEngine) Compile(name, layout string, data any, partials ...string) ([]byte, error) {
-	var buf bytes.Buffer
-	err := t.Render(&buf, name, data, append([]string{layout}, partials...)...)
+	// Inline layouts are parsed on every call and never cached
+	t.mutex.RLock()
+	tpl, err := t.parse(r)
+	t.mutex.RUnlock()
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	r.layout, r.layoutId = "inline", "inline"
+	if _, err := tpl.New("layout::inline").Parse(layoutSource); err != nil {
+		return fmt.Errorf("inline layout: %w", err)
+	}
+
+	return t.output(w, tpl, r, data)
+}
+
+func (t *tplEngine) RenderTee(w io.Writer, capture *bytes.Buffer, name string, data any, layouts ...string) error {
+	if capture == nil {
+		return t.Render(w, name, data, layouts...)
+	}
+	return t.Render(io.MultiWriter(w, capture), name, data, layouts...)
+}
+
+func (t *tplEngine) RenderPartial(w io.Writer, name string, data any) (err error) {
+	defer t.logError(name, &err)
+
+	// Load on first use or reload on development mode
+	if err := t.ensureLoaded(); err != nil {
+		return err
+	}
+
+	// Resolve partial name
+	name = normalizePath(strings.TrimPrefix(toSlash(name), t.option.partialPrefix))
+	r := &resolved{
+		viewId: t.option.partialPrefix + name,
+		entry:  t.option.partialPrefix + name,
+		key:    toKey("partial::" + name),
+	}
+
+	// Resolve Template
+	tpl, release, err := t.prepare(r, func(e *tplEngine) (*template.Template, error) {
+		if e.base.Lookup(r.entry) == nil {
+			return nil, fmt.Errorf("%s partial not found", r.entry)
+		}
+		return e.base.Clone()
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := t.output(w, tpl, r, data); err != nil {
+		return err
+	}
+	release()
+	return nil
+}
+
+func (t *tplEngine) Compile(name, layout string, data any, partials ...string) ([]byte, error) {
+	entry, err := t.compile(name, layout, data, partials...)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.Clone(entry.raw), nil
+}
+
+func (t *tplEngine) CompileHTML(name, layout string, data any, partials ...string) (template.HTML, error) {
+	entry, err := t.compile(name, layout, data, partials...)
+	if err != nil {
+		return "", err
+	}
+
+	return template.HTML(entry.raw), nil
+}
+
+func (t *tplEngine) CompileGzip(name, layout string, data any, partials ...string) ([]byte, error) {
+	entry, err := t.compile(name, layout, data, partials...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := entry.compressed()
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.Clone(res), nil
+}
+
+// compile renders the template into an output entry, serving it from the
+// output cache when enabled.
+func (t *tplEngine) compile(name, layout string, data any, partials ...string) (*outputEntry, error) {
+	layouts := t.withLayout(name, data, append([]string{layout}, partials...))
+
+	// Resolve output cache key, render hooks may depend on the request
+	key := ""
+	hooked := len(t.option.beforeRender) > 0 || len(t.option.afterRender) > 0
+	if t.outputs != nil && !t.option.Dev && !hooked {
+		if err := t.ensureLoaded(); err != nil {
+			return nil, err
+		}
+		r, err := t.resolve(name, layouts...)
+		if err != nil {
+			return nil, err
+		}
+
+		// Fail fast while a failed view cools down
+		if t.failures != nil {
+			if err := t.failures.get(r.key); err != nil {
+				return nil, err
+			}
+		}
+
+		if hash, ok := dataHash(data); ok && !matchView(t.option.uncacheable, r.viewId) {
+			key = toKey(r.key, hash)
+		}
+	}
+
+	if key != "" {
+		if entry, ok := t.outputs.get(key); ok {
+			return entry, nil
+		}
+	}
+
+	var buf bytes.Buffer
+	err := t.Render(&buf, name, data, layouts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != "" {
+		return t.outputs.put(key, buf.Bytes()), nil
+	}
+	return &outputEntry{raw: buf.Bytes()}, nil
+}
+
+func (t *tplEngine) Dependencies(name string, layouts ...string) ([]string, error) {
+	// Load on first use or reload on development mode
+	if err := t.ensureLoaded(); err != nil {
+		return nil, err
+	}
+
+	// Resolve and normalize view, layout and partials
+	r, err := t.resolve(name, layouts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Safe race condition
+	t.mutex.RLock()
+	tpl, err := t.parse(r)
+	t.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	roots := []string{r.entry}
+	if r.layout != "" {
+		roots = append(roots, "layout::"+r.layoutId)
+	}
+
+	return dependencies(tpl, roots, t.pipeName("include"), t.pipeName("require"), t.pipeName("relInclude")), nil
+}
+
+func (t *tplEngine) ContentType(name string) string {
+	view := toPath(name, t.option.root, t.option.extension)
+	view = strings.TrimSuffix(view, t.option.extension)
+
+	// Format extension before the template extension, e.g. ".json" in "user.json.tpl"
+	if ext := path.Ext(view); ext != "" {
+		if res, ok := t.option.mimes[ext+t.option.extension]; ok {
+			return res
+		}
+		if res, ok := t.option.mimes[ext]; ok {
+			return res
+		}
+		if res := mime.TypeByExtension(ext); res != "" {
+			return res
+		}
+	}
+
+	// Template extension
+	if res, ok := t.option.mimes[t.option.extension]; ok {
+		return res
+	}
+
+	return "text/html; charset=utf-8"
+}
+
+func (t *tplEngine) Stats() CacheStats {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return CacheStats{
+		Entries: len(t.templates),
+		Hits:    t.hits.Load(),
+		Misses:  t.misses.Load(),
+		Bytes:   t.bytes.Load(),
+	}
+}
+
+// renderState holds the per-render values exposed to built-in pipes.
+type renderState struct {
+	// current is the name of the view or layout being executed.
+	current string
+
+	// layout reports whether a layout is being executed.
+	layout bool
+
+	// view is the rendered child content exposed to layouts.
+	view []byte
+
+	// data is the data the view or layout is rendered with.
+	data any
+
+	// profile accumulates template execution durations when profiling.
+	profile map[string]time.Duration
+
+	// partials is shared by the view and its layout.
+	partials *partialState
+}
+
+// partialState holds the partials executed by a render and its lookups.
+type partialState struct {
+	// count is the number of partials executed, max the limit or zero.
+	count int
+	max   int
+
+	// lookups caches partial lookups by name, including misses.
+	lookups map[string]*template.Template
+}
+
+// lookup returns the named template of t, caching lookups for the render.
+func (s *renderState) lookup(t *template.Template, name string) *template.Template {
+	if s.partials == nil {
+		return t.Lookup(name)
+	}
+
+	tpl, ok := s.partials.lookups[name]
+	if !ok {
+		tpl = t.Lookup(name)
+		s.partials.lookups[name] = tpl
+	}
+	return tpl
+}
+
+// include counts a partial execution, failing past the partials limit.
+func (s *renderState) include() error {
+	if s.partials == nil {
+		return nil
+	}
+
+	s.partials.count++
+	if s.partials.max > 0 && s.partials.count > s.partials.max {
+		return fmt.Errorf("render exceeded the limit of %d partials", s.partials.max)
+	}
+	return nil
+}
+
+// track records the time elapsed since start under name when profiling.
+func (s *renderState) track(name string, start time.Time) {
+	if s.profile != nil {
+		s.profile[name] += time.Since(start)
+	}
+}
+
+// logError logs a failed render of the named view.
+func (t *tplEngine) logError(name string, err *error) {
+	if *err != nil && t.option.logger != nil {
+		t.option.logger.Error("template render failed", "view", name, "error", *err)
+	}
+}
+
+// readSource reads a template source file and passes it through the source
+// transformer registered for the longest matching file suffix, if any.
+// Transformer errors are attributed to the file.
+func (t *tplEngine) readSource(path string) ([]byte, error) {
+	content, err := t.readFile(path)
+	if err != nil || len(t.option.transformers) == 0 {
+		return content, err
+	}
+
+	ext := ""
+	for suffix := range t.option.transformers {
+		if strings.HasSuffix(path, suffix) && len(suffix) > len(ext) {
+			ext = suffix
+		}
+	}
+	if ext == "" {
+		return content, nil
+	}
+
+	content, err = t.option.transformers[ext](content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return content, nil
+}
+
+// readFile reads a file from the filesystem, giving up after the configured
+// read timeout. The timeout is best-effort: filesystems without cancellation
+// support keep reading in the background and the result is discarded.
+func (t *tplEngine) readFile(path string) ([]byte, error) {
+	// Namespaces read files they do not override from their parent
+	files := t.fs
+	if t.parent != nil && !t.overrides[path] {
+		files = t.parent.fs
+	}
+
+	if t.option.readTimeout <= 0 {
+		return files.ReadFile(path)
+	}
+
+	type result struct {
+		content []byte
+		err     error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		content, err := files.ReadFile(path)
+		ch <- result{content, err}
+	}()
+
+	timer := time.NewTimer(t.option.readTimeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-ch:
+		return res.content, res.err
+	case <-timer.C:
+		return nil, fmt.Errorf("%s read timed out after %s", path, t.option.readTimeout)
+	}
+}
+
+func (t *tplEngine) Version() uint64 {
+	if t.pin {
+		return t.parent.Version()
+	}
+	return t.version.Load()
+}
+
+func (t *tplEngine) ContentSecurityReport() []CSPViolation {
+	return t.csp.violations()
+}
+
+func (t *tplEngine) LastRenderProfile() map[string]time.Duration {
+	profile := t.profile.Load()
+	if profile == nil {
+		return nil
+	}
+	return maps.Clone(*profile)
+}
+
+// registerPipes adds built-in pipes bound to the render state to the template.
+func (t *tplEngine) registerPipes(tpl *template.Template, state *renderState) {
+	tpl.Funcs(t.decorate(t.rename(viewPipe(state))))
+	tpl.Funcs(t.decorate(t.rename(existsPipe(tpl))))
+	tpl.Funcs(t.decorate(t.rename(includePipe(tpl, state))))
+	tpl.Funcs(t.decorate(t.rename(requirePipe(tpl, state))))
+	tpl.Funcs(t.decorate(t.rename(relIncludePipe(tpl, state))))
+	if t.option.routeKey != "" {
+		tpl.Funcs(t.decorate(routePipes(state.data, t.option.routeKey)))
+	}
+	if t.option.scopeKey != "" {
+		tpl.Funcs(t.decorate(scopePipe(state.data, t.option.scopeKey)))
+	}
+	if t.option.timeZone != nil {
+		tpl.Funcs(t.decorate(datePipe(state.data, t.option.scopeKey, t.option.timeZone)))
+	}
+}
+
+// rename applies the configured names to built-in pipes.
+func (t *tplEngine) rename(pipes template.FuncMap) template.FuncMap {
+	if len(t.option.pipeNames) == 0 {
+		return pipes
+	}
+
+	res := make(template.FuncMap, len(pipes))
+	for name, fn := range pipes {
+		res[t.pipeName(name)] = fn
+	}
+	return res
+}
+
+// pipeName returns the configured name of a built-in pipe.
+func (t *tplEngine) pipeName(builtin string) string {
+	if name, ok := t.option.pipeNames[builtin]; ok {
+		return name
+	}
+	return builtin
+}
+
+// decorate wraps pipes to annotate their errors when error wrapping is enabled.
+func (t *tplEngine) decorate(pipes template.FuncMap) template.FuncMap {
+	if !t.option.wrapErrors {
+		return pipes
+	}
+
+	res := make(template.FuncMap, len(pipes))
+	for name, fn := range pipes {
+		res[name] = wrapPipe(name, fn)
+	}
+	return res
+}
+
+// output runs the render hooks around executing the resolved view into w.
+func (t *tplEngine) output(w io.Writer, tpl *template.Template, r *resolved, data any) error {
+	var err error
+
+	// Run before render hooks
+	for _, hook := range t.option.beforeRender {
+		data, err = hook(r.viewId, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Render directly when there is no output post-processing
+	scan := t.option.cspReport && t.option.Dev
+	inline := matchView(t.option.inlineCSS, r.viewId)
+	if len(t.option.afterRender) == 0 && !scan && !inline {
+		return t.execute(w, tpl, r, data)
+	}
+
+	var buf bytes.Buffer
+	if err := t.execute(&buf, tpl, r, data); err != nil {
+		return err
+	}
+
+	// Inline CSS of matching views
+	out := buf.Bytes()
+	if inline {
+		if out, err = inlineCSS(out); err != nil {
+			return err
+		}
+	}
+
+	// Run after render hooks
+	for _, hook := range t.option.afterRender {
+		out, err = hook(r.viewId, out)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Scan final output for CSP violations
+	if scan {
+		t.csp.scan(r.viewId, out)
+	}
+
+	_, err = w.Write(out)
+	return err
+}
+
+// execute renders the resolved view, injecting it into its layout if any.
+func (t *tplEngine) execute(w io.Writer, tpl *template.Template, r *resolved, data any) error {
+	var profile map[string]time.Duration
+	if t.option.profile {
+		profile = make(map[string]time.Duration)
+		defer t.profile.Store(&profile)
+	}
+
+	// Add built-in pipes
+	partials := &partialState{
+		max:     t.option.maxPartials,
+		lookups: make(map[string]*template.Template),
+	}
+	state := &renderState{current: r.viewId, data: data, profile: profile, partials: partials}
+	t.registerPipes(tpl, state)
+
+	// Render
+	start := time.Now()
+	if r.layout == "" {
+		defer state.track(r.entry, start)
+		return tpl.ExecuteTemplate(w, r.entry, underlyingValue(data))
+	} else {
+		// Render child view to layout
+		var buf bytes.Buffer
+		err := tpl.ExecuteTemplate(&buf, r.entry, underlyingValue(data))
+		state.track(r.entry, start)
+		if err != nil {
+			return err
+		}
+
+		state = &renderState{
+			current:  r.layoutId,
+			layout:   true,
+			view:     buf.Bytes(),
+			data:     data,
+			profile:  profile,
+			partials: partials,
+		}
+		t.registerPipes(tpl, state)
+
+		defer state.track("layout::"+r.layoutId, time.Now())
+		return tpl.ExecuteTemplate(w, "layout::"+r.layoutId, underlyingValue(data))
+	}
+}
+
+// resolved holds the normalized paths and identifiers of a render request.
+type resolved struct {
+	view       string
+	viewId     string
+	entry      string
+	layout     string
+	layoutId   string
+	partials   []string
+	partialsId []string
+	key        string
+	size       int
+}
+
+// resolve normalizes the view, layout and partials of a render request
+// and rejects partials that cannot be rendered directly.
+func (t *tplEngine) resolve(name string, layouts ...string) (*resolved, error) {
+	r := &resolved{
+		partials:   make([]string, 0),
+		partialsId: make([]string, 0),
+	}
+
+	// Validate names
+	for _, name := range append([]string{name}, layouts...) {
+		if err := t.checkName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	// Resolve and normalize view
+	r.view = toPath(name, t.option.root, t.option.extension)
+	r.viewId = toName(r.view, t.option.root, t.option.extension)
+	r.entry = "view::" + r.viewId
+
+	// Resolve and normalize layout and partials
+	for i := range layouts {
+		if i == 0 {
+			r.layout = toPath(layouts[0], t.option.root, t.option.extension)
+			r.layoutId = toName(r.layout, t.option.root, t.option.extension)
+		} else if layouts[i] != "" {
+			name := toPath(layouts[i], t.option.root, t.option.extension)
+			id := toName(name, t.option.root, t.option.extension)
+			r.partials = append(r.partials, name)
+			r.partialsId = append(r.partialsId, id)
+		}
+	}
+
+	// Generate key
+	r.key = toKey(append([]string{r.viewId, r.layoutId}, r.partialsId...)...)
+
+	// Check partials render
+	if t.option.partialPrefix != "" && strings.HasPrefix(name, t.option.partialPrefix) {
+		return nil, fmt.Errorf("%s partial cannot render directly", name)
+	}
+
+	if t.macroRx != nil && t.macroRx.MatchString(r.view) {
+		return nil, fmt.Errorf("%s macro cannot render directly", r.view)
+	}
+
+	if t.partialRx != nil && t.partialRx.MatchString(r.view) {
+		return nil, fmt.Errorf("%s partial cannot render directly", r.view)
+	}
+
+	if r.layout != "" && t.partialRx != nil && t.partialRx.MatchString(r.layout) {
+		return nil, fmt.Errorf("%s partial cannot render directly", r.layout)
+	}
+
+	for _, partial := range r.partials {
+		if t.partialRx != nil && t.partialRx.MatchString(partial) {
+			return nil, fmt.Errorf("%s partial already loaded globally", partial)
+		}
+	}
+
+	// Check global templates render
+	if contains(t.option.globals, r.view) {
+		return nil, fmt.Errorf("%s global template cannot render directly", r.view)
+	}
+
+	if r.layout != "" && contains(t.option.globals, r.layout) {
+		return nil, fmt.Errorf("%s global template cannot render directly", r.layout)
+	}
+
+	for _, partial := range r.partials {
+		if contains(t.option.globals, partial) {
+			return nil, fmt.Errorf("%s template already loaded globally", partial)
+		}
+	}
+
+	return r, nil
+}
+
+// cachedTemplate is a compiled template and a pool of its executable clones.
+// The prototype is never executed, so it can always be cloned. Executed
+// clones are reused by later renders, which skips cloning and escaping.
+type cachedTemplate struct {
+	proto *template.Template
+	pool  sync.Pool
+}
+
+// acquire returns an executable clone and a func to release it for reuse
+// once it executed successfully. A clone is used by one render at a time,
+// so per-render pipes never leak between concurrent renders.
+func (c *cachedTemplate) acquire() (*template.Template, func(), error) {
+	tpl, ok := c.pool.Get().(*template.Template)
+	if !ok {
+		var err error
+		if tpl, err = c.proto.Clone(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return tpl, func() { c.pool.Put(tpl) }, nil
+}
+
+// prepare returns an executable template for the resolved request and a func
+// to call after it executed successfully. compile builds the template with
+// the engine owning the cache entry. On cache miss the template is compiled
+// under the read lock and stored under the write lock, unless the templates
+// were reloaded meanwhile. No lock is held while the returned template
+// executes, so pipes may re-enter the engine and long renders never block
+// Load.
+func (t *tplEngine) prepare(r *resolved, compile func(e *tplEngine) (*template.Template, error)) (*template.Template, func(), error) {
+	// Share the parent cache when nothing is overridden
+	if t.inherits(r) {
+		return t.parent.prepare(r, compile)
+	}
+
+	// Safe race condition
+	t.mutex.RLock()
+	generation := t.generation.Load()
+	cached, ok := t.templates[r.key]
+	if ok {
+		t.mutex.RUnlock()
+		t.hits.Add(1)
+		return cached.acquire()
+	}
+
+	t.misses.Add(1)
+	if t.option.logger != nil {
+		t.option.logger.Debug("template cache miss", "key", r.key)
+	}
+	proto, err := compile(t)
+	t.mutex.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Uncached templates are local to this render
+	if t.option.Dev || !t.option.Cache || matchView(t.option.uncacheable, r.viewId) {
+		return proto, func() {}, nil
+	}
+
+	// Store to cache
+	cached = &cachedTemplate{proto: proto}
+	t.mutex.Lock()
+	if _, exists := t.templates[r.key]; !exists && t.generation.Load() == generation {
+		t.templates[r.key] = cached
+		t.bytes.Add(int64(r.size))
+	}
+	t.mutex.Unlock()
+
+	return cached.acquire()
+}
+
+// withLayout picks a layout with the layout resolver when no explicit
+// layout is given. The resolved layout becomes part of the cache key.
+func (t *tplEngine) withLayout(name string, data any, layouts []string) []string {
+	if t.option.layoutResolver == nil || (len(layouts) > 0 && layouts[0] != "") {
+		return layouts
+	}
+
+	view := toName(toPath(name, t.option.root, t.option.extension), t.option.root, t.option.extension)
+	layout := t.option.layoutResolver(view, data)
+	if len(layouts) == 0 {
+		return []string{layout}
+	}
+	return append([]string{layout}, layouts[1:]...)
+}
+
+// checkName rejects template names carrying the template extension
+// when extensionless names are enforced.
+func (t *tplEngine) checkName(name string) error {
+	if t.option.extensionless && strings.HasSuffix(name, t.option.extension) {
+		return fmt.Errorf(
+			"%s: template names must not include the extension, use %q instead",
+			name, strings.TrimSuffix(name, t.option.extension),
+		)
+	}
+	return nil
+}
+
+// parse clones the base engine and parses the resolved view, layout and
+// partials into it. Caller must hold the engine lock.
+func (t *tplEngine) parse(r *resolved) (*template.Template, error) {
+	// Clone from base engine
+	tpl, err := t.base.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read and parse auto included templates
+	for _, file := range t.option.autoIncludes {
+		if raw, err := t.readSource(file); os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s auto include template not found", file)
+		} else if err != nil {
+			return nil, err
+		} else {
+			r.size += len(raw)
+			name := toName(file, t.option.root, t.option.extension)
+			if _, err := tpl.New(name).Parse(string(raw)); err != nil {
+				return nil, &parseError{err}
+			}
+		}
+	}
+
+	// Read and parse view
+	if raw, err := t.readSource(r.view); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s template not found", r.view)
+	} else if err != nil {
+		return nil, err
+	} else {
+		r.size += len(raw)
+		_, err := tpl.New(r.entry).Parse(string(raw))
+		if err != nil {
+			return nil, &parseError{err}
+		}
+	}
+
+	// Read and parse layout
+	if r.layout != "" {
+		if raw, err := t.readSource(r.layout); os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s layout template not found", r.layout)
+		} else if err != nil {
+			return nil, err
+		} else {
+			r.size += len(raw)
+			_, err := tpl.New("layout::" + r.layoutId).Parse(string(raw))
+			if err != nil {
+				return nil, &parseError{err}
+			}
+		}
+	}
+
+	for i := range r.partials {
+		if raw, err := t.readSource(r.partials[i]); os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s partial template not found", r.partials[i])
+		} else if err != nil {
+			return nil, err
+		} else {
+			r.size += len(raw)
+			_, err := tpl.New(r.partialsId[i]).Parse(string(raw))
+			if err != nil {
+				return nil, &parseError{err}
+			}
+		}
 	}
 
-	return buf.Bytes(), nil
+	return tpl, nil
 }
@@ -0,0 +1,89 @@
+package template
+
+import (
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// CSPViolation describes rendered markup that a strict Content Security
+// Policy would block.
+type CSPViolation struct {
+	// View is the normalized name of the rendered view.
+	View string
+
+	// Kind is one of "inline-script", "inline-style" or "javascript-url".
+	Kind string
+
+	// Snippet is the offending markup, truncated.
+	Snippet string
+}
+
+var (
+	cspScriptRx = regexp.MustCompile(`(?i)<script\b[^>]*>`)
+	cspSrcRx    = regexp.MustCompile(`(?i)\ssrc\s*=`)
+	cspNonceRx  = regexp.MustCompile(`(?i)\snonce\s*=`)
+	cspStyleRx  = regexp.MustCompile(`(?i)<[a-z][^>]*\sstyle\s*=[^>]*>`)
+	cspURLRx    = regexp.MustCompile(`(?i)\s(?:href|src|action|formaction)\s*=\s*["']?\s*javascript:`)
+)
+
+// cspReport holds the violations found in the latest render of each view.
+type cspReport struct {
+	views map[string][]CSPViolation
+	mutex sync.Mutex
+}
+
+// scan records the violations found in the rendered output of view,
+// replacing those of its previous render.
+func (c *cspReport) scan(view string, output []byte) {
+	violations := make([]CSPViolation, 0)
+	add := func(kind string, snippet []byte) {
+		const limit = 80
+		if len(snippet) > limit {
+			snippet = append(snippet[:limit:limit], "..."...)
+		}
+		violations = append(violations, CSPViolation{View: view, Kind: kind, Snippet: string(snippet)})
+	}
+
+	for _, tag := range cspScriptRx.FindAll(output, -1) {
+		if !cspSrcRx.Match(tag) && !cspNonceRx.Match(tag) {
+			add("inline-script", tag)
+		}
+	}
+	for _, tag := range cspStyleRx.FindAll(output, -1) {
+		add("inline-style", tag)
+	}
+	for _, attr := range cspURLRx.FindAll(output, -1) {
+		add("javascript-url", attr)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.views == nil {
+		c.views = make(map[string][]CSPViolation)
+	}
+	if len(violations) == 0 {
+		delete(c.views, view)
+	} else {
+		c.views[view] = violations
+	}
+}
+
+// violations returns all recorded violations ordered by view.
+func (c *cspReport) violations() []CSPViolation {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	views := make([]string, 0, len(c.views))
+	for view := range c.views {
+		views = append(views, view)
+	}
+	sort.Strings(views)
+
+	res := make([]CSPViolation, 0)
+	for _, view := range views {
+		res = append(res, c.views[view]...)
+	}
+	return res
+}
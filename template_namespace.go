@@ -0,0 +1,202 @@
+package template
+
+import (
+	"html/template"
+	"os"
+	"slices"
+
+	"github.com/go-universal/fs"
+)
+
+func (t *tplEngine) Namespace(overlay fs.FlexibleFS) Template {
+	engine := &tplEngine{
+		option:    t.option,
+		fs:        overlay,
+		partialRx: t.partialRx,
+		macroRx:   t.macroRx,
+		parent:    t,
+	}
+	engine.lazy.Store(&lazyLoad{})
+	if t.option.outputCacheSize > 0 {
+		engine.outputs = newOutputCache(t.option.outputCacheSize, t.option.outputCacheTTL, t.option.now)
+	}
+	if t.failures != nil {
+		engine.failures = newFailureCache(failureCacheSize, t.option.errorCooldown, t.option.now)
+	}
+	return engine
+}
+
+// pinned returns a namespace without overlay pinned to the currently loaded
+// templates of t. It never loads nor reloads, so after load hooks can call
+// back into the engine while the load is still in progress.
+func (t *tplEngine) pinned() *tplEngine {
+	engine := &tplEngine{
+		option:    t.option,
+		fs:        t.fs,
+		partialRx: t.partialRx,
+		macroRx:   t.macroRx,
+		pin:       true,
+		parent:    t,
+		templates: make(map[string]*cachedTemplate),
+		overrides: make(map[string]bool),
+	}
+	engine.lazy.Store(&lazyLoad{})
+
+	// Safe race condition
+	t.mutex.RLock()
+	engine.base = t.base
+	engine.partialNames = t.partialNames
+	t.mutex.RUnlock()
+	return engine
+}
+
+// inherits reports whether the namespace can serve the resolved request
+// from its parent: neither the view, the layout, the partials, the auto
+// included nor the shared templates are overridden.
+func (t *tplEngine) inherits(r *resolved) bool {
+	if t.parent == nil {
+		return false
+	}
+
+	// Safe race condition
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	t.parent.mutex.RLock()
+	base := t.parent.base
+	t.parent.mutex.RUnlock()
+
+	if t.base != base || t.overrides[r.view] || t.overrides[r.layout] {
+		return false
+	}
+	for _, partial := range r.partials {
+		if t.overrides[partial] {
+			return false
+		}
+	}
+	for _, file := range t.option.autoIncludes {
+		if t.overrides[file] {
+			return false
+		}
+	}
+	return true
+}
+
+// loadNamespace loads the overlay of a namespace. The parent base is shared
+// as is, unless the overlay overrides partials, macros or global templates.
+// Then the namespace base is rebuilt from the parent parse trees, which are
+// shared without copying, with the overridden files parsed on top.
+func (t *tplEngine) loadNamespace() error {
+	// Load parent first
+	if err := t.parent.ensureLoaded(); err != nil {
+		return err
+	}
+
+	// Safe race condition
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Initialize
+	t.generation.Add(1)
+	t.templates = make(map[string]*cachedTemplate)
+	t.bytes.Store(0)
+	if t.outputs != nil {
+		t.outputs.clear()
+	}
+	if t.failures != nil {
+		t.failures.clear()
+	}
+
+	t.parent.mutex.RLock()
+	parent := t.parent.base
+	t.partialNames = slices.Clone(t.parent.partialNames)
+	t.parentGen.Store(t.parent.generation.Load())
+	t.parent.mutex.RUnlock()
+
+	// Read overlay files, a missing root overrides nothing
+	files, err := t.fs.Lookup(
+		t.option.root,
+		extPattern("", t.option.extension),
+	)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Share the parent base unless shared templates are overridden
+	t.overrides = make(map[string]bool)
+	shared := false
+	for _, file := range files {
+		t.overrides[file] = true
+		shared = shared || t.isShared(file)
+	}
+	if !shared {
+		t.base = parent
+		return nil
+	}
+
+	// Rebuild base over the parent parse trees
+	t.base = template.New("").
+		Delims(t.option.leftDelim, t.option.rightDelim).
+		Funcs(t.decorate(t.option.Pipes))
+	t.registerPipes(t.base, &renderState{})
+	for _, item := range parent.Templates() {
+		if item.Tree == nil || item.Name() == "" {
+			continue
+		}
+		if _, err := t.base.AddParseTree(item.Name(), item.Tree); err != nil {
+			return err
+		}
+	}
+
+	// Load overridden macros
+	if t.macroRx != nil {
+		if err := t.loadMacros(files); err != nil {
+			return err
+		}
+	}
+
+	// Load overridden partials and global templates
+	for _, file := range files {
+		var name string
+		switch {
+		case t.partialRx != nil && t.partialRx.MatchString(file):
+			name = toName(file, t.option.partials, t.option.extension)
+			if !contains(t.partialNames, name) {
+				t.partialNames = append(t.partialNames, name)
+			}
+			name = t.option.partialPrefix + name
+		case contains(t.option.globals, file):
+			name = toName(file, t.option.root, t.option.extension)
+		default:
+			continue
+		}
+
+		content, err := t.readSource(file)
+		if err != nil {
+			return err
+		}
+
+		if _, err := t.base.New(name).Parse(string(content)); err != nil {
+			return err
+		}
+	}
+
+	// Point aliases to overridden partials
+	if t.option.partialAliases {
+		if err := t.loadAliases(t.partialNames); err != nil {
+			return err
+		}
+	}
+
+	if t.option.logger != nil {
+		t.option.logger.Debug("namespace templates loaded", "overrides", len(files))
+	}
+
+	return nil
+}
+
+// isShared reports whether the file is loaded into the shared base.
+func (t *tplEngine) isShared(file string) bool {
+	return (t.partialRx != nil && t.partialRx.MatchString(file)) ||
+		(t.macroRx != nil && t.macroRx.MatchString(file)) ||
+		contains(t.option.globals, file)
+}
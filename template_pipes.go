@@ -5,80 +5,249 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"path"
+	"strings"
+	"sync"
+	"time"
 )
 
-// viewPipe registers a custom "view" function for rendering a child template
-// inside a layout template. It returns an error if the child template fails
-// to render or if "view" is called from a non-layout template.
-func viewPipe(t *template.Template, data []byte) {
-	t.Funcs(map[string]any{
+// viewPipe creates a custom "view" function for rendering a child template
+// inside a layout template. It returns an error if "view" is called from a
+// non-layout template.
+//
+// The child view is always executed before its layout, so "view" only emits
+// the buffered output: a layout may call it any number of times, including
+// zero or conditionally, and an empty child view renders as empty output.
+func viewPipe(state *renderState) template.FuncMap {
+	return template.FuncMap{
 		"view": func() (template.HTML, error) {
-			if data == nil {
+			if !state.layout {
 				return "", errors.New("layout template called without view")
 			}
-			return template.HTML(data), nil
+			return template.HTML(state.view), nil
 		},
-	})
+	}
 }
 
-// existsPipe registers a custom "exists" function to the template engine.
+// existsPipe creates a custom "exists" function for the template engine.
 // The "exists" function checks if a template with the given name exists.
-func existsPipe(t *template.Template) {
-	t.Funcs(map[string]any{
+func existsPipe(t *template.Template) template.FuncMap {
+	return template.FuncMap{
 		"exists": func(name string) bool {
 			return t.Lookup(name) != nil
 		},
-	})
+	}
 }
 
-// includePipe registers a custom "include" function to the template engine.
+// includePipe creates a custom "include" function for the template engine.
 // The "include" function includes and executes a template with the given name.
 // If the template does not exist, it returns an empty string without error.
-func includePipe(t *template.Template) {
-	t.Funcs(map[string]any{
+func includePipe(t *template.Template, state *renderState) template.FuncMap {
+	return template.FuncMap{
 		"include": func(name string, data ...any) (template.HTML, error) {
-			tpl := t.Lookup(name)
+			tpl := state.lookup(t, name)
 			if tpl == nil {
 				return "", nil
 			}
 
-			var v any
-			if len(data) > 0 {
-				v = data[0]
-			}
-
-			var buf bytes.Buffer
-			if err := tpl.Execute(&buf, underlyingValue(v)); err != nil {
-				return "", err
-			}
-
-			return template.HTML(buf.String()), nil
+			return executePartial(tpl, state, data...)
 		},
-	})
+	}
 }
 
-// requirePipe registers a custom "require" function to the template engine.
+// requirePipe creates a custom "require" function for the template engine.
 // The "require" function includes and executes a template with the given name.
 // If the template does not exist, it returns an error.
-func requirePipe(t *template.Template) {
-	t.Funcs(map[string]any{
+func requirePipe(t *template.Template, state *renderState) template.FuncMap {
+	return template.FuncMap{
 		"require": func(name string, data ...any) (template.HTML, error) {
-			tpl := t.Lookup(name)
+			tpl := state.lookup(t, name)
 			if tpl == nil {
 				return "", fmt.Errorf("template %s does not exist", name)
 			}
 
-			var v any
-			if len(data) > 0 {
-				v = data[0]
-			}
+			return executePartial(tpl, state, data...)
+		},
+	}
+}
 
-			var buf bytes.Buffer
-			if err := tpl.Execute(&buf, underlyingValue(v)); err != nil {
+// relIncludePipe creates a custom "relInclude" function for the template engine.
+// The "relInclude" function works like "include" but resolves names starting
+// with "./" or "../" relative to the directory of the current template. Other
+// names are looked up as is. Resolving outside of the root returns an error.
+//
+// The current template is the including template: the rendered view, the
+// layout while the layout executes, or the file of the partial being
+// included, e.g. "./x" resolves to "@partials/ui/x" inside "@partials/ui/card",
+// even when the partial is included through an alias.
+func relIncludePipe(t *template.Template, state *renderState) template.FuncMap {
+	return template.FuncMap{
+		"relInclude": func(name string, data ...any) (template.HTML, error) {
+			resolved, err := relativeName(state.current, name)
+			if err != nil {
 				return "", err
 			}
 
-			return template.HTML(buf.String()), nil
+			tpl := state.lookup(t, resolved)
+			if tpl == nil {
+				return "", nil
+			}
+
+			return executePartial(tpl, state, data...)
 		},
-	})
+	}
+}
+
+// routePipes creates custom "isActive" and "isExact" functions for the template
+// engine, comparing a target path with the current path read from the render
+// data under key. Query strings, fragments and trailing slashes are ignored.
+// "isActive" also matches nested paths, except for the root path "/" which
+// only matches exactly.
+func routePipes(data any, key string) template.FuncMap {
+	current := ""
+	switch v := underlyingValue(data).(type) {
+	case map[string]any:
+		current, _ = v[key].(string)
+	case map[string]string:
+		current = v[key]
+	}
+	current = cleanRoute(current)
+
+	return template.FuncMap{
+		"isActive": func(target string) bool {
+			target = cleanRoute(target)
+			if current == target {
+				return true
+			}
+			return target != "/" && strings.HasPrefix(current, target+"/")
+		},
+		"isExact": func(target string) bool {
+			return current == cleanRoute(target)
+		},
+	}
+}
+
+// scopePipe creates a custom "scope" function for the template engine that
+// returns the per-render value stored under name in the scope read from the
+// render data under key.
+func scopePipe(data any, key string) template.FuncMap {
+	scope := scopeValues(data, key)
+	return template.FuncMap{
+		"scope": func(name string) any {
+			return scope[name]
+		},
+	}
+}
+
+// cleanRoute strips the query string, fragment and trailing slash of a path.
+func cleanRoute(route string) string {
+	if i := strings.IndexAny(route, "?#"); i >= 0 {
+		route = route[:i]
+	}
+	if route = strings.TrimRight(route, "/"); route == "" {
+		return "/"
+	}
+	return route
+}
+
+// relativeName resolves a "./" or "../" prefixed name against the
+// directory of the current template name.
+func relativeName(current, name string) (string, error) {
+	if !strings.HasPrefix(name, "./") && !strings.HasPrefix(name, "../") {
+		return name, nil
+	}
+
+	res := path.Join(path.Dir(current), name)
+	if res == ".." || strings.HasPrefix(res, "../") {
+		return "", fmt.Errorf("template %s escapes the root from %s", name, current)
+	}
+	return res, nil
+}
+
+// executePartial executes the template with the optional data
+// and returns its output as HTML, tracking its duration when profiling.
+func executePartial(tpl *template.Template, state *renderState, data ...any) (template.HTML, error) {
+	if err := state.include(); err != nil {
+		return "", err
+	}
+	defer state.track(tpl.Name(), time.Now())
+
+	// Resolve relative includes against the partial file, not its alias
+	current := state.current
+	state.current = sourceName(tpl)
+	defer func() { state.current = current }()
+
+	var v any
+	if len(data) > 0 {
+		v = data[0]
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, underlyingValue(v)); err != nil {
+		return "", err
+	}
+
+	return template.HTML(buf.String()), nil
+}
+
+// datePipe creates a custom "date" function for the template engine that
+// formats times in loc. The "timezone" scope value read from the render data
+// under key overrides loc, as a *time.Location or a zone name. Zone names are
+// loaded on the first call of "date".
+func datePipe(data any, key string, loc *time.Location) template.FuncMap {
+	var name string
+	if key != "" {
+		switch zone := scopeValues(data, key)["timezone"].(type) {
+		case *time.Location:
+			if zone != nil {
+				loc = zone
+			}
+		case string:
+			name = zone
+		}
+	}
+
+	return template.FuncMap{
+		"date": func(v any, layout string) (string, error) {
+			if name != "" {
+				zone, err := loadLocation(name)
+				if err != nil {
+					return "", err
+				}
+				loc, name = zone, ""
+			}
+
+			t, ok, err := toTime("date", v)
+			if !ok {
+				return "", err
+			}
+			return t.In(loc).Format(layout), nil
+		},
+	}
+}
+
+// locations memoizes the zones loaded by name for the "date" pipe.
+var locations sync.Map
+
+// loadLocation loads the named zone once. Unknown names are not
+// remembered, so data cannot grow the memo.
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// scopeValues returns the scope read from the render data under key.
+func scopeValues(data any, key string) map[string]any {
+	if v, ok := underlyingValue(data).(map[string]any); ok {
+		return ToContext(v[key]).Data()
+	}
+	return nil
 }
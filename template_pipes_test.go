@@ -0,0 +1,97 @@
+package template
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRelIncludeFromPartial(t *testing.T) {
+	tpl := newTestEngine(t, map[string]string{
+		"views/pages/home.tpl":        `{{ include "@partials/ui/card" }}|{{ relInclude "./x" }}{{ define "pages/x" }}view-x{{ end }}`,
+		"views/partials/ui/card.tpl":  `card:{{ relInclude "./title" }}:{{ relInclude "../icon" }}`,
+		"views/partials/ui/title.tpl": `card-title`,
+		"views/partials/icon.tpl":     `icon`,
+	})
+
+	var buf bytes.Buffer
+	if err := tpl.Render(&buf, "pages/home", nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "card:card-title:icon|view-x"; buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+
+	deps, err := tpl.Dependencies("pages/home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"@partials/ui/card", "@partials/ui/title", "@partials/icon"} {
+		if !slices.Contains(deps, name) {
+			t.Errorf("dependencies %v missing %s", deps, name)
+		}
+	}
+}
+
+func TestRelIncludeFromAliasedPartial(t *testing.T) {
+	tpl := newTestEngine(t, map[string]string{
+		"views/home.tpl":                    `{{ include "@partials/input" }}`,
+		"views/partials/ui/forms/input.tpl": `input:{{ relInclude "./hint" }}`,
+		"views/partials/ui/forms/hint.tpl":  `forms-hint`,
+		"views/partials/admin/hint.tpl":     `admin-hint`,
+	}, WithPartialAliases())
+
+	var buf bytes.Buffer
+	if err := tpl.Render(&buf, "home", nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "input:forms-hint"; buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+
+	deps, err := tpl.Dependencies("home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(deps, "@partials/ui/forms/hint") || slices.Contains(deps, "@partials/admin/hint") {
+		t.Fatalf("unexpected dependencies %v", deps)
+	}
+}
+
+func TestDateZoneName(t *testing.T) {
+	tpl := newTestEngine(t, map[string]string{
+		"views/date.tpl":  `{{ date .At "15:04 MST" }}`,
+		"views/plain.tpl": `plain`,
+	}, WithTimeZone(time.UTC), WithTemplateFuncContext("ctx"))
+
+	at := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	render := func(view, zone string) (string, error) {
+		var buf bytes.Buffer
+		err := tpl.Render(&buf, view, map[string]any{"At": at, "ctx": map[string]any{"timezone": zone}})
+		return buf.String(), err
+	}
+
+	if _, err := render("plain", "Nowhere/Unknown"); err != nil {
+		t.Fatalf("unused date pipe failed the render: %v", err)
+	}
+	if _, err := render("date", "Nowhere/Unknown"); err == nil {
+		t.Fatal("expected an unknown zone error")
+	}
+	if _, ok := locations.Load("Nowhere/Unknown"); ok {
+		t.Fatal("unknown zone was memoized")
+	}
+
+	for range 2 {
+		got, err := render("date", "Asia/Tokyo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "21:00 JST"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+	if _, ok := locations.Load("Asia/Tokyo"); !ok {
+		t.Fatal("zone was not memoized")
+	}
+}
@@ -0,0 +1,76 @@
+package template
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+)
+
+func (t *tplEngine) RenderSandboxed(w io.Writer, source string, data any) (err error) {
+	defer t.logError("sandbox", &err)
+
+	// Load on first use or reload on development mode
+	if err := t.ensureLoaded(); err != nil {
+		return err
+	}
+
+	// Collect allowlisted pipes
+	pipes := make(template.FuncMap)
+	for _, name := range t.option.sandboxPipes {
+		if fn, ok := t.option.Pipes[name]; ok {
+			pipes[name] = fn
+		}
+	}
+
+	// Parse source with allowlisted pipes only
+	tpl, err := template.New("sandbox").
+		Delims(t.option.leftDelim, t.option.rightDelim).
+		Funcs(t.decorate(pipes)).
+		Parse(source)
+	if err != nil {
+		return fmt.Errorf("sandbox: %w", err)
+	}
+
+	// Reject references outside of the source and the allowlist
+	for _, def := range tpl.Templates() {
+		if def.Tree == nil {
+			continue
+		}
+		for _, ref := range references(def.Tree.Root) {
+			if tpl.Lookup(ref) == nil && !contains(t.option.sandboxTemplates, ref) {
+				return fmt.Errorf("sandbox: template %s is not allowed", ref)
+			}
+		}
+	}
+
+	// Copy allowlisted shared templates
+	if err := t.sandboxTemplates(tpl); err != nil {
+		return err
+	}
+
+	return tpl.ExecuteTemplate(w, "sandbox", underlyingValue(data))
+}
+
+// sandboxTemplates copies allowlisted shared templates into the sandbox.
+// Parse trees are copied, so escaping the sandbox never alters the base.
+func (t *tplEngine) sandboxTemplates(tpl *template.Template) error {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	for _, name := range t.option.sandboxTemplates {
+		if tpl.Lookup(name) != nil {
+			continue
+		}
+
+		shared := t.base.Lookup(name)
+		if shared == nil || shared.Tree == nil {
+			return fmt.Errorf("sandbox: template %s does not exist", name)
+		}
+
+		if _, err := tpl.AddParseTree(name, shared.Tree.Copy()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
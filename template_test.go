@@ -0,0 +1,451 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-universal/fs"
+)
+
+// newTestEngine creates an engine over files written to a temporary
+// directory, with views rooted at "views" and partials in "views/partials".
+func newTestEngine(t testing.TB, files map[string]string, options ...Options) Template {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	options = append([]Options{
+		WithRoot("views"),
+		WithPartials("views/partials"),
+	}, options...)
+	return New(fs.NewDir(dir), options...)
+}
+
+func TestCompressionCacheSkipsLossyData(t *testing.T) {
+	type user struct {
+		Name   string
+		Hidden string `json:"-"`
+	}
+
+	calls := 0
+	tpl := newTestEngine(t, map[string]string{
+		"views/page.tpl": `<p>{{ count }}{{ .Name }}-{{ .Hidden }}</p>`,
+	}, WithCompressionCache(10), WithPipes("count", func() string {
+		calls++
+		return ""
+	}))
+
+	alice, err := tpl.Compile("page", "", user{Name: "x", Hidden: "alice-private"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := tpl.Compile("page", "", user{Name: "x", Hidden: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(alice) == string(bob) {
+		t.Fatalf("struct data served from cache: %s", bob)
+	}
+
+	// JSON-native maps are cached
+	calls = 0
+	for range 2 {
+		if _, err := tpl.Compile("page", "", Ctx().Add("Name", "x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected one render of cacheable data, got %d", calls)
+	}
+}
+
+func TestExtensionlessNames(t *testing.T) {
+	tpl := newTestEngine(t, map[string]string{
+		"views/home.tpl":             `home`,
+		"views/home.tpl.bak.tpl":     `backup`,
+		"views/pages/v1.2/about.tpl": `about`,
+		"views/pages/user.json.tpl":  `{}`,
+	}, WithExtensionlessNames())
+
+	tests := []struct {
+		name, want string
+		fail       bool
+	}{
+		{name: "home", want: "home"},
+		{name: "/home", want: "home"},
+		{name: "home.tpl.bak", want: "backup"},
+		{name: "pages/v1.2/about", want: "about"},
+		{name: "/pages/v1.2/about", want: "about"},
+		{name: "pages/user.json", want: "{}"},
+		{name: "home.tpl", fail: true},
+		{name: "/pages/v1.2/about.tpl", fail: true},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := tpl.Render(&buf, tt.name, nil)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected extension error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if buf.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestAfterLoadErrorFailsLoad(t *testing.T) {
+	calls := 0
+	fail := true
+	tpl := newTestEngine(t, map[string]string{
+		"views/home.tpl": `home`,
+	}, WithAfterLoad(func(Template) error {
+		calls++
+		if fail {
+			return errors.New("hook failed")
+		}
+		return nil
+	}))
+
+	if err := tpl.Load(); err == nil {
+		t.Fatal("expected Load to fail")
+	}
+	if err := tpl.Render(io.Discard, "home", nil); err == nil {
+		t.Fatal("expected Render to fail while the hook fails")
+	}
+	if calls != 2 {
+		t.Fatalf("expected the hook to run on every load attempt, got %d", calls)
+	}
+	if v := tpl.Version(); v != 0 {
+		t.Fatalf("expected version 0 after failed loads, got %d", v)
+	}
+
+	fail = false
+	if err := tpl.Render(io.Discard, "home", nil); err != nil {
+		t.Fatal(err)
+	}
+	if v := tpl.Version(); v != 1 {
+		t.Fatalf("expected version 1 after a successful load, got %d", v)
+	}
+}
+
+func TestAfterLoadErrorConcurrentRenders(t *testing.T) {
+	tpl := newTestEngine(t, map[string]string{
+		"views/home.tpl": `home`,
+	}, WithAfterLoad(func(t Template) error {
+		// Hooks may call back into the engine
+		if ok, err := t.Exists("home"); err != nil || !ok {
+			return fmt.Errorf("home view missing: %v", err)
+		}
+		if err := t.Render(io.Discard, "home", nil); err != nil {
+			return err
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		return errors.New("hook failed")
+	}))
+
+	var wg sync.WaitGroup
+	var succeeded atomic.Int32
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := tpl.Render(io.Discard, "home", nil); err == nil {
+				succeeded.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := succeeded.Load(); n != 0 {
+		t.Fatalf("%d renders succeeded against a load rejected by its hook", n)
+	}
+}
+
+// partialSet returns a view including n partials, each with a few actions.
+func partialSet(n int) map[string]string {
+	var view strings.Builder
+	files := make(map[string]string, n+1)
+	for i := range n {
+		name := fmt.Sprintf("group%d/item%d", i%10, i)
+		files["views/partials/"+name+".tpl"] = strings.Repeat(
+			`<div class="{{ .Class }}">{{ if .Title }}<h2>{{ .Title }}</h2>{{ end }}{{ range .Items }}<span>{{ . }}</span>{{ end }}</div>`, 5,
+		)
+		fmt.Fprintf(&view, `{{ include "@partials/%s" . }}`, name)
+	}
+	files["views/page.tpl"] = view.String()
+	return files
+}
+
+func BenchmarkLoadPartials(b *testing.B) {
+	files := partialSet(500)
+	for _, bench := range []struct {
+		name    string
+		options []Options
+	}{
+		{"NoCache", nil},
+		{"SourceCache", []Options{WithPartialSourceCache()}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			tpl := newTestEngine(b, files, bench.options...)
+			if err := tpl.Load(); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for range b.N {
+				if err := tpl.Load(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReentrantPipe(t *testing.T) {
+	var tpl Template
+	nested := func(name string) (template.HTML, error) {
+		var buf bytes.Buffer
+		err := tpl.Render(&buf, name, nil)
+		return template.HTML(buf.String()), err
+	}
+	tpl = newTestEngine(t, map[string]string{
+		"views/outer.tpl":         `outer[{{ nested "inner" }}]{{ include "@partials/card" . }}`,
+		"views/inner.tpl":         `inner`,
+		"views/partials/card.tpl": `card{{ .N }}`,
+	}, WithCache(), WithPipes("nested", nested))
+
+	// Pipes calling back into the engine must not deadlock
+	done := make(chan error, 1)
+	go func() {
+		var buf bytes.Buffer
+		done <- tpl.Render(&buf, "outer", nil)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("re-entrant render deadlocked")
+	}
+
+	// Concurrent cached renders share pooled clones
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := range 200 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%50 == 0 {
+				tpl.Reload()
+			}
+
+			var buf bytes.Buffer
+			if err := tpl.Render(&buf, "outer", map[string]any{"N": i}); err != nil {
+				errs <- err
+				return
+			}
+			if want := fmt.Sprintf("outer[inner]card%d", i); buf.String() != want {
+				errs <- fmt.Errorf("got %q, want %q", buf.String(), want)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestOutputCacheBypassedWithHooks(t *testing.T) {
+	nonce := 0
+	tpl := newTestEngine(t, map[string]string{
+		"views/page.tpl": `<script nonce="{{ .Nonce }}"></script>`,
+	}, WithOutputCache(10, 0), WithBeforeRender(func(view string, data any) (any, error) {
+		nonce++
+		return Ctx().Add("Nonce", nonce), nil
+	}))
+
+	first, err := tpl.Compile("page", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tpl.Compile("page", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) == string(second) {
+		t.Fatalf("hooked output served from cache: %s", second)
+	}
+}
+
+func TestPartialAliases(t *testing.T) {
+	tpl := newTestEngine(t, map[string]string{
+		"views/page.tpl":                    `{{ include "@partials/input" }}|{{ include "@partials/card" }}`,
+		"views/partials/ui/forms/input.tpl": `form-input`,
+		"views/partials/admin/input.tpl":    `admin-input`,
+		"views/partials/ui/card.tpl":        `card`,
+	}, WithPartialAliases())
+
+	var buf bytes.Buffer
+	if err := tpl.Render(&buf, "page", nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "|card"; buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+
+	colliding := newTestEngine(t, map[string]string{
+		"views/partials/ui/card.tpl": `card`,
+		"views/partials/card.tpl":    `card`,
+	}, WithPartialAliases())
+	if err := colliding.Load(); err == nil {
+		t.Fatal("expected Load to fail on an alias colliding with a partial")
+	}
+}
+
+func BenchmarkRenderPartials(b *testing.B) {
+	files := partialSet(60)
+	data := map[string]any{"Class": "item", "Title": "Title", "Items": []string{"a", "b", "c"}}
+	for _, bench := range []struct {
+		name   string
+		pooled bool
+	}{
+		{"CloneEachRender", false},
+		{"PooledClones", true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			tpl := newTestEngine(b, files, WithCache())
+			if err := tpl.Render(io.Discard, "page", data); err != nil {
+				b.Fatal(err)
+			}
+			engine := tpl.(*tplEngine)
+
+			b.ResetTimer()
+			for range b.N {
+				// Drop executed clones to clone the prototype every render
+				if !bench.pooled {
+					for _, cached := range engine.templates {
+						cached.pool = sync.Pool{}
+					}
+				}
+				if err := tpl.Render(io.Discard, "page", data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorCooldown(t *testing.T) {
+	now := time.Unix(0, 0)
+	tpl := newTestEngine(t, map[string]string{
+		"views/json.tpl":     `{{ (fromJson .J).a }}`,
+		"views/broken.tpl":   `{{ if }}`,
+		"views/unclosed.tpl": `<a href="{{ .URL }}>link</a>`,
+	}, WithJSONPipe(), WithErrorCooldown(time.Minute), WithClock(func() time.Time { return now }))
+
+	// Execution errors caused by request data are not remembered
+	if err := tpl.Render(io.Discard, "json", map[string]any{"J": "{bad"}); err == nil {
+		t.Fatal("expected invalid JSON to fail the render")
+	}
+	var buf bytes.Buffer
+	if err := tpl.Render(&buf, "json", map[string]any{"J": `{"a":"ok"}`}); err != nil {
+		t.Fatalf("valid data failed after a data error: %v", err)
+	}
+
+	// Read errors such as a missing view are not remembered
+	missing := tpl.Render(io.Discard, "missing", nil)
+	if missing == nil {
+		t.Fatal("expected a missing view error")
+	}
+	if err := tpl.Render(io.Discard, "missing", nil); err == nil || err == missing {
+		t.Fatalf("expected a fresh missing view error, got %v", err)
+	}
+
+	// Compile and escaping errors are remembered until the cooldown elapses
+	cached := make(map[string]error)
+	for _, view := range []string{"broken", "unclosed"} {
+		cached[view] = tpl.Render(io.Discard, view, nil)
+		if cached[view] == nil {
+			t.Fatalf("%s: expected error", view)
+		}
+		if err := tpl.Render(io.Discard, view, nil); err != cached[view] {
+			t.Fatalf("%s: expected the cached error, got %v", view, err)
+		}
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := tpl.Render(io.Discard, "broken", nil); err == nil || err == cached["broken"] {
+		t.Fatalf("expected a fresh error after the cooldown, got %v", err)
+	}
+}
+
+func TestFailureCacheBound(t *testing.T) {
+	now := time.Unix(0, 0)
+	cache := newFailureCache(2, time.Minute, func() time.Time { return now })
+	errA, errB, errC := errors.New("a"), errors.New("b"), errors.New("c")
+
+	cache.put("a", errA)
+	cache.put("b", errB)
+	cache.put("c", errC)
+	if len(cache.entries) != 2 || cache.get("c") != nil {
+		t.Fatalf("full cache stored a new entry: %v", cache.entries)
+	}
+	cache.put("a", errC)
+	if cache.get("a") != errC {
+		t.Fatal("full cache did not update an existing entry")
+	}
+
+	now = now.Add(2 * time.Minute)
+	cache.put("c", errC)
+	if len(cache.entries) != 1 || cache.get("c") != errC {
+		t.Fatalf("elapsed entries were not dropped: %v", cache.entries)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	tpl := newTestEngine(t, nil,
+		WithExtensionMIME(".json.tpl", "application/vnd.x+json"),
+		WithExtensionMIME(".csv", "text/x-csv"),
+	)
+
+	tests := []struct {
+		name, want string
+	}{
+		{"api/user.json", "application/vnd.x+json"},
+		{"api/user.json.tpl", "application/vnd.x+json"},
+		{"report.csv", "text/x-csv"},
+		{"pages/home.tpl", "text/html; charset=utf-8"},
+		{"pages/home", "text/html; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		if got := tpl.ContentType(tt.name); got != tt.want {
+			t.Errorf("ContentType(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
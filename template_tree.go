@@ -0,0 +1,172 @@
+package template
+
+import (
+	"html/template"
+	"strings"
+	"text/template/parse"
+)
+
+// DynamicDependency is reported in place of template references whose
+// name is computed at runtime and cannot be resolved statically.
+const DynamicDependency = "dynamic/unknown"
+
+// dependencies walks the parse trees of the root templates and returns the
+// names of all templates they reference, directly or transitively, through
+// template actions and the given include-like pipes.
+func dependencies(t *template.Template, roots []string, pipes ...string) []string {
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		seen[root] = true
+	}
+
+	queue := append([]string{}, roots...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		tpl := t.Lookup(name)
+		if tpl == nil || tpl.Tree == nil || tpl.Tree.Root == nil {
+			continue
+		}
+
+		for _, ref := range references(tpl.Tree.Root, pipes...) {
+			// Resolve relInclude names against the referencing file
+			if ref != DynamicDependency {
+				resolved, err := relativeName(sourceName(tpl), ref)
+				if err != nil {
+					continue
+				}
+				ref = resolved
+			}
+
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			res = append(res, ref)
+			if ref != DynamicDependency {
+				queue = append(queue, ref)
+			}
+		}
+	}
+
+	return res
+}
+
+// sourceName returns the name relative includes of a template resolve
+// against: the name of the file it was parsed from, without the view or
+// layout entry prefix. Aliases and defines resolve like their file.
+func sourceName(tpl *template.Template) string {
+	name := tpl.Name()
+	if tpl.Tree != nil && tpl.Tree.ParseName != "" {
+		name = tpl.Tree.ParseName
+	}
+	if rest, ok := strings.CutPrefix(name, "view::"); ok {
+		return rest
+	}
+	if rest, ok := strings.CutPrefix(name, "layout::"); ok {
+		return rest
+	}
+	return name
+}
+
+// references returns the template names referenced by node through template
+// actions and calls to the given include-like pipes. References with a
+// computed name are reported as DynamicDependency.
+func references(node parse.Node, pipes ...string) []string {
+	res := make([]string, 0)
+
+	var walk func(parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			res = append(res, n.Name)
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			if len(n.Args) > 0 {
+				if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && contains(pipes, ident.Ident) {
+					if len(n.Args) > 1 {
+						if str, ok := n.Args[1].(*parse.StringNode); ok {
+							res = append(res, str.Text)
+						} else {
+							res = append(res, DynamicDependency)
+						}
+					}
+				}
+			}
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		}
+	}
+	walk(node)
+
+	return res
+}
+
+// unresolved returns the names referenced through template actions and the
+// given required pipes that are not defined, in the root templates and every
+// template they reach through the optional and required pipes.
+func unresolved(t *template.Template, roots []string, optional []string, required []string) []string {
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+	names := append(append([]string{}, roots...), dependencies(t, roots, append(optional, required...)...)...)
+	for _, name := range names {
+		tpl := t.Lookup(name)
+		if tpl == nil || tpl.Tree == nil || tpl.Tree.Root == nil {
+			continue
+		}
+
+		for _, ref := range references(tpl.Tree.Root, required...) {
+			if ref == DynamicDependency || seen[ref] || t.Lookup(ref) != nil {
+				continue
+			}
+			seen[ref] = true
+			res = append(res, ref)
+		}
+	}
+
+	return res
+}
+
+// missing returns the names referenced by the root templates, and every
+// template they reach through the given pipes, that are not defined.
+// Relative names are resolved, dynamic references are skipped.
+func missing(t *template.Template, roots []string, pipes ...string) []string {
+	res := make([]string, 0)
+	for _, ref := range dependencies(t, roots, pipes...) {
+		if ref != DynamicDependency && t.Lookup(ref) == nil {
+			res = append(res, ref)
+		}
+	}
+	return res
+}
@@ -0,0 +1,288 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+)
+
+// Report describes the health of every view, as returned by ValidateReport.
+type Report struct {
+	// Err is the error that prevented validation, e.g. a load failure.
+	Err error
+
+	// Files lists the status of each template file in lookup order.
+	// Shared partials, macros and global templates are only parsed; their
+	// references and escaping are checked through the views using them.
+	Files []FileReport
+}
+
+// FileReport describes the health of a single template file.
+type FileReport struct {
+	// File is the path of the template file.
+	File string
+
+	// View is the normalized name of the file, relative to the root.
+	View string
+
+	// Status is one of "ok", "parse-error", "missing-reference" or
+	// "escape-error".
+	Status string
+
+	// Details lists the parse or escaping error, or the missing template
+	// references.
+	Details []string
+
+	// Dynamic reports references with a name computed at runtime, which
+	// cannot be validated.
+	Dynamic bool
+
+	// Warnings lists include and relInclude references that do not resolve.
+	// They render empty, so they do not change the status.
+	Warnings []string
+}
+
+// OK reports whether templates loaded and every file is healthy.
+func (r Report) OK() bool {
+	if r.Err != nil {
+		return false
+	}
+	for _, file := range r.Files {
+		if file.Status != "ok" {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *tplEngine) Check(name, layout string, partials ...string) error {
+	// Load on first use or reload on development mode
+	if err := t.ensureLoaded(); err != nil {
+		return err
+	}
+
+	// Resolve and normalize view, layout and partials
+	r, err := t.resolve(name, append([]string{layout}, partials...)...)
+	if err != nil {
+		return err
+	}
+
+	// Safe race condition
+	t.mutex.RLock()
+	tpl, err := t.parse(r)
+	t.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	roots := []string{r.entry}
+	if r.layout != "" {
+		roots = append(roots, "layout::"+r.layoutId)
+	}
+
+	// Check static references
+	var errs []error
+	for _, ref := range t.unresolved(tpl, roots) {
+		errs = append(errs, fmt.Errorf("template %s does not exist", ref))
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return t.escape(tpl, roots)
+}
+
+// unresolved returns the template and require references of the root
+// templates, and the partials they include, that are not defined.
+func (t *tplEngine) unresolved(tpl *template.Template, roots []string) []string {
+	optional := []string{t.pipeName("include"), t.pipeName("relInclude")}
+	required := []string{t.pipeName("require")}
+	return unresolved(tpl, roots, optional, required)
+}
+
+// unresolvedIncludes returns the include and relInclude references of the
+// root templates, and the partials they include, that are not defined.
+// Missing template and require references are reported by unresolved.
+func (t *tplEngine) unresolvedIncludes(tpl *template.Template, roots []string) []string {
+	required := t.unresolved(tpl, roots)
+	pipes := []string{t.pipeName("include"), t.pipeName("require"), t.pipeName("relInclude")}
+
+	res := make([]string, 0)
+	for _, ref := range missing(tpl, roots, pipes...) {
+		if !contains(required, ref) {
+			res = append(res, ref)
+		}
+	}
+	return res
+}
+
+// escape runs the html/template escaper over the root templates and the
+// templates they statically reference without executing them, so no pipe
+// is called. Each template is called from a template branch that is never
+// taken, which escapes it like a render would. tpl must not be executed
+// afterwards.
+func (t *tplEngine) escape(tpl *template.Template, roots []string) error {
+	pipes := []string{t.pipeName("include"), t.pipeName("require"), t.pipeName("relInclude")}
+	left, right := t.option.leftDelim, t.option.rightDelim
+
+	// Templates cannot be parsed after execution, create all callers first
+	names := make([]string, 0)
+	callers := make(map[string]*template.Template)
+	for _, name := range append(roots, dependencies(tpl, roots, pipes...)...) {
+		if tpl.Lookup(name) == nil {
+			continue
+		}
+
+		source := fmt.Sprintf("%s if false %s%s template %q . %s%s end %s", left, right, left, name, right, left, right)
+		caller, err := tpl.New("check::" + name).Parse(source)
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+		callers[name] = caller
+	}
+
+	var errs []error
+	for _, name := range names {
+		err := callers[name].Execute(io.Discard, nil)
+
+		// Report templates ending in a non-text context like a render
+		var escapeErr *template.Error
+		if errors.As(err, &escapeErr) && escapeErr.ErrorCode == template.ErrBranchEnd && escapeErr.Name == "check::"+name {
+			err = fmt.Errorf("html/template:%s: ends in a non-text context", name)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *tplEngine) Validate() error {
+	report, errs := t.validate()
+	if t.option.logger != nil {
+		for _, file := range report.Files {
+			if file.Dynamic {
+				t.option.logger.Warn("dynamic template reference cannot be validated", "view", file.View)
+			}
+			for _, warning := range file.Warnings {
+				t.option.logger.Warn(warning, "view", file.View)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *tplEngine) ValidateReport() Report {
+	report, _ := t.validate()
+	return report
+}
+
+// validate parses every shared file on its own and compiles every view
+// without caching it, checking its static references and escaping. It
+// returns the report and the problems found as errors.
+func (t *tplEngine) validate() (Report, []error) {
+	var report Report
+
+	// Read templates from fs
+	files, err := t.fs.Lookup(
+		t.option.root,
+		extPattern("", t.option.extension),
+	)
+	if err != nil {
+		report.Err = err
+		return report, []error{err}
+	}
+
+	// Report shared templates, views cannot compile when they fail to load
+	loadErr := t.ensureLoaded()
+	for _, file := range files {
+		if t.isShared(file) {
+			report.Files = append(report.Files, t.validateShared(file))
+		}
+	}
+	if loadErr != nil {
+		report.Err = loadErr
+		return report, []error{loadErr}
+	}
+
+	var errs []error
+	for _, file := range files {
+		if t.isShared(file) {
+			continue
+		}
+
+		name := toName(file, t.option.root, t.option.extension)
+		status := FileReport{File: file, View: name, Status: "ok"}
+		r, err := t.resolve(name)
+		if err != nil {
+			status.Status, status.Details = "parse-error", []string{err.Error()}
+			report.Files = append(report.Files, status)
+			errs = append(errs, err)
+			continue
+		}
+
+		// Safe race condition
+		t.mutex.RLock()
+		tpl, err := t.parse(r)
+		t.mutex.RUnlock()
+		if err != nil {
+			status.Status, status.Details = "parse-error", []string{err.Error()}
+			report.Files = append(report.Files, status)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+
+		// Report dynamic references
+		pipes := []string{t.pipeName("include"), t.pipeName("require"), t.pipeName("relInclude")}
+		status.Dynamic = contains(dependencies(tpl, []string{r.entry}, pipes...), DynamicDependency)
+
+		// Check static references
+		for _, ref := range t.unresolved(tpl, []string{r.entry}) {
+			status.Status = "missing-reference"
+			status.Details = append(status.Details, fmt.Sprintf("template %s does not exist", ref))
+			errs = append(errs, fmt.Errorf("%s: template %s does not exist", name, ref))
+		}
+
+		// Warn about includes rendering empty
+		for _, ref := range t.unresolvedIncludes(tpl, []string{r.entry}) {
+			status.Warnings = append(status.Warnings, fmt.Sprintf("included template %s does not exist", ref))
+		}
+
+		// Escape like a render, missing references fail escaping too
+		if status.Status == "ok" {
+			if err := t.escape(tpl, []string{r.entry}); err != nil {
+				status.Status, status.Details = "escape-error", []string{err.Error()}
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+
+		report.Files = append(report.Files, status)
+	}
+
+	return report, errs
+}
+
+// validateShared parses a partial, macro or global template file on its
+// own, so a broken shared file is reported even when loading fails.
+func (t *tplEngine) validateShared(file string) FileReport {
+	status := FileReport{
+		File:   file,
+		View:   toName(file, t.option.root, t.option.extension),
+		Status: "ok",
+	}
+
+	content, err := t.readSource(file)
+	if err == nil {
+		tpl := template.New(status.View).
+			Delims(t.option.leftDelim, t.option.rightDelim).
+			Funcs(t.decorate(t.option.Pipes))
+		t.registerPipes(tpl, &renderState{})
+		_, err = tpl.Parse(string(content))
+	}
+	if err != nil {
+		status.Status, status.Details = "parse-error", []string{err.Error()}
+	}
+	return status
+}
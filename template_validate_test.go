@@ -0,0 +1,149 @@
+package template
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	calls := 0
+	tpl := newTestEngine(t, map[string]string{
+		"views/ok.tpl":              `{{ count }}<p>{{ include "@partials/card" . }}</p>`,
+		"views/missing.tpl":         `{{ template "nope" }}`,
+		"views/required.tpl":        `{{ require "@partials/gone" }}`,
+		"views/unclosed.tpl":        `<a href="{{ .URL }}>link</a>`,
+		"views/syntax.tpl":          `{{ if }}`,
+		"views/layout.tpl":          `<main>{{ view }}</main>`,
+		"views/layout-broken.tpl":   `<div class="{{ view }}>`,
+		"views/partials/card.tpl":   `<b>card</b>`,
+		"views/partials/broken.tpl": `<b title="{{ . }}>`,
+		"views/uses-broken.tpl":     `{{ include "@partials/broken" }}`,
+	}, WithPipes("count", func() string {
+		calls++
+		return ""
+	}))
+
+	tests := []struct {
+		name, layout string
+		fail         bool
+	}{
+		{name: "ok", layout: "layout"},
+		{name: "ok"},
+		{name: "missing", fail: true},
+		{name: "required", fail: true},
+		{name: "unclosed", fail: true},
+		{name: "syntax", fail: true},
+		{name: "ok", layout: "layout-broken", fail: true},
+		{name: "ok", layout: "absent", fail: true},
+		{name: "uses-broken", fail: true},
+	}
+	for _, tt := range tests {
+		err := tpl.Check(tt.name, tt.layout)
+		if tt.fail && err == nil {
+			t.Errorf("%s/%s: expected error", tt.name, tt.layout)
+		} else if !tt.fail && err != nil {
+			t.Errorf("%s/%s: %v", tt.name, tt.layout, err)
+		}
+	}
+
+	if calls != 0 {
+		t.Fatalf("Check executed pipes %d times", calls)
+	}
+	if stats := tpl.Stats(); stats.Entries != 0 {
+		t.Fatalf("Check cached %d templates", stats.Entries)
+	}
+}
+
+func TestValidateReport(t *testing.T) {
+	tpl := newTestEngine(t, map[string]string{
+		"views/pages/ok.tpl":       `{{ include "@partials/card" }}`,
+		"views/pages/missing.tpl":  `{{ require "@partials/gone" }}`,
+		"views/pages/unclosed.tpl": `<a href="{{ .URL }}>link</a>`,
+		"views/pages/syntax.tpl":   `{{ if }}`,
+		"views/partials/card.tpl":  `<b>card</b>`,
+	})
+
+	report := tpl.ValidateReport()
+	if report.Err != nil {
+		t.Fatal(report.Err)
+	}
+	if report.OK() {
+		t.Fatal("expected an unhealthy report")
+	}
+
+	want := map[string]string{
+		"pages/ok":       "ok",
+		"pages/missing":  "missing-reference",
+		"pages/unclosed": "escape-error",
+		"pages/syntax":   "parse-error",
+		"partials/card":  "ok",
+	}
+	if len(report.Files) != len(want) {
+		t.Fatalf("expected %d files, got %+v", len(want), report.Files)
+	}
+	for _, file := range report.Files {
+		if file.Status != want[file.View] {
+			t.Errorf("%s: got %s %v, want %s", file.View, file.Status, file.Details, want[file.View])
+		}
+	}
+	if stats := tpl.Stats(); stats.Entries != 0 {
+		t.Fatalf("ValidateReport cached %d templates", stats.Entries)
+	}
+}
+
+func TestValidateReportBrokenPartial(t *testing.T) {
+	tpl := newTestEngine(t, map[string]string{
+		"views/pages/ok.tpl":        `ok`,
+		"views/partials/card.tpl":   `<b>card</b>`,
+		"views/partials/broken.tpl": `{{ range }}`,
+	})
+
+	report := tpl.ValidateReport()
+	if report.Err == nil {
+		t.Fatal("expected the load error")
+	}
+
+	status := make(map[string]string)
+	for _, file := range report.Files {
+		status[file.View] = file.Status
+	}
+	if status["partials/broken"] != "parse-error" || status["partials/card"] != "ok" {
+		t.Fatalf("unexpected shared file statuses %v", status)
+	}
+}
+
+type warnLogger struct{ warnings []string }
+
+func (l *warnLogger) Debug(msg string, fields ...any) {}
+func (l *warnLogger) Error(msg string, fields ...any) {}
+func (l *warnLogger) Warn(msg string, fields ...any) {
+	l.warnings = append(l.warnings, msg)
+}
+
+func TestValidateMissingIncludes(t *testing.T) {
+	logger := &warnLogger{}
+	tpl := newTestEngine(t, map[string]string{
+		"views/pages/home.tpl":    `{{ include "@partials/card" }}{{ include "@partials/gone" }}{{ relInclude "./nope" }}`,
+		"views/partials/card.tpl": `{{ include "@partials/icon" }}`,
+	}, WithLogger(logger))
+
+	if err := tpl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"included template @partials/gone does not exist",
+		"included template pages/nope does not exist",
+		"included template @partials/icon does not exist",
+	}
+	for _, warning := range want {
+		if !slices.Contains(logger.warnings, warning) {
+			t.Errorf("warnings %v missing %q", logger.warnings, warning)
+		}
+	}
+
+	for _, file := range tpl.ValidateReport().Files {
+		if file.View == "pages/home" && (file.Status != "ok" || len(file.Warnings) != len(want)) {
+			t.Fatalf("unexpected report %+v", file)
+		}
+	}
+}
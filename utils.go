@@ -1,14 +1,36 @@
 package template
 
 import (
-	"path/filepath"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"path"
+	"reflect"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// normalizePath joins and cleans paths, then converts them to use slashes as separators.
+// normalizePath joins and cleans paths using slashes as separators. Backslashes
+// are treated as separators too, so names resolve the same way on every OS.
 func normalizePath(paths ...string) string {
-	return filepath.ToSlash(filepath.Clean(filepath.Join(paths...)))
+	parts := make([]string, len(paths))
+	for i, p := range paths {
+		parts[i] = toSlash(p)
+	}
+	return path.Clean(path.Join(parts...))
+}
+
+// toSlash replaces backslash separators with slashes.
+func toSlash(p string) string {
+	return strings.ReplaceAll(p, "\\", "/")
 }
 
 // toName converts a file path to a name by removing the root and extension.
@@ -16,7 +38,7 @@ func toName(path, root, ext string) string {
 	if path == "" {
 		return ""
 	}
-	path = strings.TrimPrefix(path, root)
+	path = strings.TrimPrefix(toSlash(path), toSlash(root))
 	path = strings.TrimSuffix(path, ext)
 	return normalizePath(path)
 }
@@ -26,7 +48,7 @@ func toPath(name, root, ext string) string {
 	if name == "" {
 		return ""
 	}
-	name = strings.TrimPrefix(name, root)
+	name = strings.TrimPrefix(toSlash(name), toSlash(root))
 	name = strings.TrimSuffix(name, ext)
 	return normalizePath(root, name+ext)
 }
@@ -58,9 +80,461 @@ func underlyingValue(v any) any {
 }
 
 // extPattern creates a regular expression pattern to match paths with a specific extension.
+// The path is expected to be normalized with slash separators.
 func extPattern(path, ext string) string {
 	if path == "" {
-		return ".*" + regexp.QuoteMeta(ext)
+		return ".*" + regexp.QuoteMeta(ext) + "$"
+	}
+	return "^" + regexp.QuoteMeta(toSlash(path)) + ".*" + regexp.QuoteMeta(ext) + "$"
+}
+
+// wrapPipe decorates a pipe function so that a returned error is annotated
+// with the pipe name and its arguments. Functions without an error result
+// are returned unchanged.
+func wrapPipe(name string, fn any) any {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return fn
+	}
+
+	typ := v.Type()
+	errType := reflect.TypeFor[error]()
+	if typ.NumOut() == 0 || typ.Out(typ.NumOut()-1) != errType {
+		return fn
+	}
+
+	return reflect.MakeFunc(typ, func(args []reflect.Value) []reflect.Value {
+		var res []reflect.Value
+		if typ.IsVariadic() {
+			res = v.CallSlice(args)
+		} else {
+			res = v.Call(args)
+		}
+
+		last := res[len(res)-1]
+		if last.IsNil() {
+			return res
+		}
+
+		err := fmt.Errorf("pipe %s(%s): %w", name, formatArgs(args, typ.IsVariadic()), last.Interface().(error))
+		res[len(res)-1] = reflect.ValueOf(&err).Elem()
+		return res
+	}).Interface()
+}
+
+// formatArgs renders pipe arguments as a comma separated list,
+// truncating long values.
+func formatArgs(args []reflect.Value, variadic bool) string {
+	const limit = 32
+
+	values := make([]any, 0, len(args))
+	for i, arg := range args {
+		if variadic && i == len(args)-1 {
+			for j := 0; j < arg.Len(); j++ {
+				values = append(values, arg.Index(j).Interface())
+			}
+		} else {
+			values = append(values, arg.Interface())
+		}
+	}
+
+	parts := make([]string, len(values))
+	for i, value := range values {
+		s := fmt.Sprintf("%#v", value)
+		if len(s) > limit {
+			s = s[:limit] + "..."
+		}
+		parts[i] = s
+	}
+	return strings.Join(parts, ", ")
+}
+
+// contains reports whether items contains the given value.
+func contains(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmpty reports whether val is nil, a nil pointer or interface, an empty
+// string, slice, map or channel, or a zero number.
+func isEmpty(val any) bool {
+	if val == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Chan:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return v.IsZero()
+	}
+	return false
+}
+
+// matchView reports whether the view name matches one of the glob patterns.
+// Invalid patterns never match.
+func matchView(patterns []string, view string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, view); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedTypes are the html/template types whose values are emitted without
+// escaping.
+var trustedTypes = []reflect.Type{
+	reflect.TypeFor[template.HTML](),
+	reflect.TypeFor[template.HTMLAttr](),
+	reflect.TypeFor[template.JS](),
+	reflect.TypeFor[template.JSStr](),
+	reflect.TypeFor[template.CSS](),
+	reflect.TypeFor[template.URL](),
+	reflect.TypeFor[template.Srcset](),
+}
+
+// auditPipes returns an error for every pipe declaring a trusted content
+// result that is not in the trusted list. Results declared as interfaces
+// cannot be audited.
+func auditPipes(pipes template.FuncMap, trusted []string) error {
+	names := make([]string, 0, len(pipes))
+	for name := range pipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		t := reflect.TypeOf(pipes[name])
+		if t == nil || t.Kind() != reflect.Func || contains(trusted, name) {
+			continue
+		}
+		for i := 0; i < t.NumOut(); i++ {
+			if slices.Contains(trustedTypes, t.Out(i)) {
+				errs = append(errs, fmt.Errorf("%s pipe returns %s but is not trusted", name, t.Out(i)))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// dataHash returns a stable hash of the render data. It reports false
+// unless the data is a map or Context of JSON-native values, since the JSON
+// encoding of other values may omit fields and map distinct data to the
+// same hash.
+func dataHash(data any) (string, bool) {
+	data = underlyingValue(data)
+	if _, ok := data.(map[string]any); !ok && data != nil {
+		return "", false
+	}
+	if !jsonNative(data) {
+		return "", false
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), true
+}
+
+// jsonNative reports whether v is fully described by its JSON encoding: nil,
+// strings, booleans, numbers, and slices or string keyed maps of those.
+func jsonNative(v any) bool {
+	switch val := v.(type) {
+	case nil, string, bool, float32, float64,
+		int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64:
+		return true
+	case []string, []int, []int64, []float64, []bool, map[string]string:
+		return true
+	case Context:
+		return jsonNative(val.data)
+	case *Context:
+		return val != nil && jsonNative(val.data)
+	case []any:
+		for _, item := range val {
+			if !jsonNative(item) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		for _, item := range val {
+			if !jsonNative(item) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// isEscapeError reports whether err was raised while escaping a template,
+// which fails every render of the template regardless of its data.
+func isEscapeError(err error) bool {
+	var escapeErr *template.Error
+	return errors.As(err, &escapeErr)
+}
+
+// parseError marks a template syntax error, as opposed to an error reading
+// the template source.
+type parseError struct {
+	err error
+}
+
+func (e *parseError) Error() string { return e.err.Error() }
+
+func (e *parseError) Unwrap() error { return e.err }
+
+// isCompileError reports whether err is a syntax or escaping error, which
+// fails every render of the template until its source changes.
+func isCompileError(err error) bool {
+	var syntaxErr *parseError
+	return errors.As(err, &syntaxErr) || isEscapeError(err)
+}
+
+// toTime converts a time.Time or *time.Time argument of the named pipe. It
+// reports false for a nil *time.Time or an unsupported type.
+func toTime(name string, v any) (time.Time, bool, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true, nil
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, false, nil
+		}
+		return *t, true, nil
+	default:
+		return time.Time{}, false, fmt.Errorf("%s expects time.Time, got %T", name, v)
+	}
+}
+
+// toFloat converts a numeric value to float64.
+func toFloat(v any) (float64, error) {
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(val.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(val.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return val.Float(), nil
+	default:
+		return 0, fmt.Errorf("%T is not a number", v)
+	}
+}
+
+// mergeData shallow merges maps, contexts and structs into a new map. Later
+// values override earlier ones on key collision. Nil values and nil pointers
+// are skipped. Structs contribute their exported fields by field name,
+// except fields promoted through nil embedded pointers.
+func mergeData(values ...any) (map[string]any, error) {
+	res := make(map[string]any)
+	for _, value := range values {
+		v := reflect.ValueOf(underlyingValue(value))
+		for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+			v = v.Elem()
+		}
+
+		switch {
+		case !v.IsValid():
+			continue
+		case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+			iter := v.MapRange()
+			for iter.Next() {
+				res[iter.Key().String()] = iter.Value().Interface()
+			}
+		case v.Kind() == reflect.Struct:
+			for _, field := range reflect.VisibleFields(v.Type()) {
+				if !field.IsExported() || field.Anonymous {
+					continue
+				}
+
+				// Skip fields promoted through nil embedded pointers
+				fv, err := v.FieldByIndexErr(field.Index)
+				if err != nil || !fv.CanInterface() {
+					continue
+				}
+				res[field.Name] = fv.Interface()
+			}
+		default:
+			return nil, fmt.Errorf("mergeData expects maps, contexts or structs, got %T", value)
+		}
+	}
+	return res, nil
+}
+
+// sliceOf returns the sub-slice coll[start:end] of a slice, array or string,
+// clamping the bounds to the collection length instead of panicking. Strings
+// are sliced by runes.
+func sliceOf(name string, coll any, start, end int) (any, error) {
+	v := reflect.ValueOf(underlyingValue(coll))
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	// Resolve collection
+	var runes []rune
+	switch v.Kind() {
+	case reflect.String:
+		runes = []rune(v.String())
+		v = reflect.ValueOf(runes)
+	case reflect.Array:
+		if !v.CanAddr() {
+			arr := reflect.New(v.Type()).Elem()
+			arr.Set(v)
+			v = arr
+		}
+	case reflect.Slice:
+	default:
+		return nil, fmt.Errorf("%s expects slice, array or string, got %T", name, coll)
+	}
+
+	// Clamp bounds
+	end = min(max(end, 0), v.Len())
+	start = min(max(start, 0), end)
+
+	if runes != nil {
+		return string(runes[start:end]), nil
+	}
+	return v.Slice(start, end).Interface(), nil
+}
+
+// itemOf returns the item of a slice, array or string at i, or nil when out
+// of range. Negative indexes count from the end. Strings are indexed by runes.
+func itemOf(name string, coll any, i int) (any, error) {
+	items, err := sliceOf(name, coll, 0, math.MaxInt)
+	if err != nil {
+		return nil, err
+	}
+
+	v := reflect.ValueOf(items)
+	str, isString := items.(string)
+	if isString {
+		v = reflect.ValueOf([]rune(str))
+	}
+	if i < 0 {
+		i += v.Len()
+	}
+	if i < 0 || i >= v.Len() {
+		return nil, nil
+	}
+
+	if isString {
+		return string(v.Index(i).Interface().(rune)), nil
+	}
+	return v.Index(i).Interface(), nil
+}
+
+// humanizeBytes formats a byte size using binary or SI units.
+func humanizeBytes(size float64, si bool) string {
+	base, units := 1024.0, []string{"B", "KB", "MB", "GB", "TB", "PB", "EB"}
+	if si {
+		base, units = 1000.0, []string{"B", "kB", "MB", "GB", "TB", "PB", "EB"}
+	}
+
+	i := 0
+	for math.Abs(size) >= base && i < len(units)-1 {
+		size /= base
+		i++
+	}
+
+	if i == 0 {
+		return strconv.FormatFloat(size, 'f', -1, 64) + " " + units[i]
+	}
+	res := strconv.FormatFloat(size, 'f', 1, 64)
+	res = strings.TrimSuffix(res, ".0")
+	return res + " " + units[i]
+}
+
+// humanizeTime formats t relative to now, e.g. "3 minutes ago" or "in 2 days".
+func humanizeTime(t, now time.Time) string {
+	diff := now.Sub(t)
+	future := diff < 0
+	if future {
+		diff = -diff
+	}
+
+	if diff < time.Minute {
+		return "just now"
+	}
+
+	var amount int64
+	var unit string
+	switch {
+	case diff < time.Hour:
+		amount, unit = int64(diff/time.Minute), "minute"
+	case diff < 24*time.Hour:
+		amount, unit = int64(diff/time.Hour), "hour"
+	case diff < 30*24*time.Hour:
+		amount, unit = int64(diff/(24*time.Hour)), "day"
+	case diff < 365*24*time.Hour:
+		amount, unit = int64(diff/(30*24*time.Hour)), "month"
+	default:
+		amount, unit = int64(diff/(365*24*time.Hour)), "year"
+	}
+
+	if amount != 1 {
+		unit += "s"
+	}
+	if future {
+		return fmt.Sprintf("in %d %s", amount, unit)
+	}
+	return fmt.Sprintf("%d %s ago", amount, unit)
+}
+
+// ordinal formats n with its English ordinal suffix, e.g. "21st".
+func ordinal(n int64) string {
+	rem := n % 100
+	if rem < 0 {
+		rem = -rem
+	}
+
+	suffix := "th"
+	if rem < 11 || rem > 13 {
+		switch rem % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return strconv.FormatInt(n, 10) + suffix
+}
+
+// IsSafe reports whether v is already trusted HTML, i.e. a template.HTML
+// such as the output of the include, require and br pipes.
+func IsSafe(v any) bool {
+	_, ok := v.(template.HTML)
+	return ok
+}
+
+// EnsureSafe returns v as template.HTML, escaping it only if it is not
+// already trusted HTML. Calling it repeatedly never double-escapes.
+func EnsureSafe(v any) template.HTML {
+	switch val := v.(type) {
+	case template.HTML:
+		return val
+	case nil:
+		return ""
+	case string:
+		return template.HTML(template.HTMLEscapeString(val))
+	default:
+		return template.HTML(template.HTMLEscapeString(fmt.Sprint(val)))
 	}
-	return "^" + regexp.QuoteMeta(path) + ".*" + regexp.QuoteMeta(ext)
 }
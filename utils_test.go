@@ -0,0 +1,90 @@
+package template
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestToName(t *testing.T) {
+	tests := []struct {
+		path, root, want string
+	}{
+		{"views/pages/home.tpl", "views/", "pages/home"},
+		{`views\pages\home.tpl`, "views/", "pages/home"},
+		{"views/pages/home.tpl", `views\`, "pages/home"},
+		{`views\partials\ui\card.tpl`, `views\partials\`, "ui/card"},
+		{"views/pages/../home.tpl", "views/", "home"},
+		{"", "views/", ""},
+	}
+	for _, tt := range tests {
+		if got := toName(tt.path, tt.root, ".tpl"); got != tt.want {
+			t.Errorf("toName(%q, %q) = %q, want %q", tt.path, tt.root, got, tt.want)
+		}
+	}
+}
+
+func TestToPath(t *testing.T) {
+	tests := []struct {
+		name, root, want string
+	}{
+		{"pages/home", "views/", "views/pages/home.tpl"},
+		{`pages\home`, "views/", "views/pages/home.tpl"},
+		{"pages/home.tpl", "views/", "views/pages/home.tpl"},
+		{`views\pages\home`, "views/", "views/pages/home.tpl"},
+		{"pages/home", `views\`, "views/pages/home.tpl"},
+		{"home", ".", "home.tpl"},
+		{"", "views/", ""},
+	}
+	for _, tt := range tests {
+		if got := toPath(tt.name, tt.root, ".tpl"); got != tt.want {
+			t.Errorf("toPath(%q, %q) = %q, want %q", tt.name, tt.root, got, tt.want)
+		}
+	}
+}
+
+func TestExtPattern(t *testing.T) {
+	tests := []struct {
+		path, file string
+		want       bool
+	}{
+		{"views/partials/", "views/partials/card.tpl", true},
+		{"views/partials/", "views/partials/ui/card.tpl", true},
+		{`views\partials\`, "views/partials/card.tpl", true},
+		{"views/partials/", "views/pages/card.tpl", false},
+		{"views/partials/", "views/partials/card.tpl.bak", false},
+		{"views/partials/", "views/partials/card.tplx", false},
+		{"", "views/pages/home.tpl", true},
+		{"", "views/pages/home.tpl~", false},
+		{"views/(partials)/", "views/(partials)/card.tpl", true},
+	}
+	for _, tt := range tests {
+		rx := regexp.MustCompile(extPattern(tt.path, ".tpl"))
+		if got := rx.MatchString(tt.file); got != tt.want {
+			t.Errorf("extPattern(%q) match %q = %v, want %v", tt.path, tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestMergeDataEmbeddedPointer(t *testing.T) {
+	type Inner struct{ B int }
+	type Outer struct {
+		*Inner
+		A int
+	}
+
+	res, err := mergeData(Outer{A: 1}, map[string]any{"C": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res["B"]; ok || res["A"] != 1 || res["C"] != 3 {
+		t.Fatalf("unexpected merge result %v", res)
+	}
+
+	res, err = mergeData(Outer{Inner: &Inner{B: 2}, A: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["A"] != 1 || res["B"] != 2 {
+		t.Fatalf("unexpected merge result %v", res)
+	}
+}